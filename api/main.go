@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
+	// Timezones of repeating todos must resolve on hosts without zoneinfo
+	_ "time/tzdata"
 
+	"github.com/yogisyo16/root-aura-service/blobstore"
 	"github.com/yogisyo16/root-aura-service/db"
 	"github.com/yogisyo16/root-aura-service/handlers"
+	"github.com/yogisyo16/root-aura-service/notifier"
+	"github.com/yogisyo16/root-aura-service/scheduler"
 	"github.com/yogisyo16/root-aura-service/services"
 )
 
@@ -24,16 +32,69 @@ func main() {
 
 	// 2. Initialize the services with the database client
 	todoService := services.New(mongoClient)
+	if err := services.EnsureIndexes(context.Background()); err != nil {
+		log.Println("Some indexes could not be created: ", err)
+	}
 	userService := services.User{} // Uses the same client set in services.New()
 	detailsService := services.NewTodoDetailsService(mongoClient)
+	tagService := services.NewTagService(mongoClient)
+	projectService := services.NewProjectService(mongoClient)
+	subtaskService := services.NewSubtaskService(mongoClient)
+	reminderService := services.NewReminderService(mongoClient)
+	digestService := services.NewDigestService(mongoClient)
+	commentService := services.NewCommentService(mongoClient)
+	store, err := blobstore.FromEnv()
+	if err != nil {
+		log.Fatal("Could not open the blob store: ", err)
+	}
+	attachmentService := services.NewAttachmentService(mongoClient, store)
 
 	// 3. Initialize the handlers with their respective services
-	todoHandler := handlers.NewTodoHandler(todoService, detailsService) // Pass both services
-	userHandler := handlers.NewUserHandler(userService)
-	detailsHandler := handlers.NewTodoDetailsHandler(detailsService)
+	todoHandler := handlers.NewTodoHandler(todoService, detailsService, tagService, projectService, subtaskService, reminderService, commentService) // Pass all services
+	userHandler := handlers.NewUserHandler(userService, digestService)
+	detailsHandler := handlers.NewTodoDetailsHandler(detailsService, todoService)
+	tagHandler := handlers.NewTagHandler(tagService, todoService)
+	projectHandler := handlers.NewProjectHandler(projectService)
+	subtaskHandler := handlers.NewSubtaskHandler(subtaskService, todoService, detailsService)
+	reminderHandler := handlers.NewReminderHandler(reminderService, todoService)
+	commentHandler := handlers.NewCommentHandler(commentService, todoService)
+	attachmentHandler := handlers.NewAttachmentHandler(attachmentService, todoService)
+
+	handlers.RequireIfMatch = os.Getenv("REQUIRE_IF_MATCH") == "true"
 
 	// 4. Create the router and pass all handlers to it
-	router := handlers.CreateRouter(todoHandler, userHandler, detailsHandler)
+	router := handlers.CreateRouter(todoHandler, userHandler, detailsHandler, tagHandler, projectHandler, subtaskHandler, reminderHandler, commentHandler, attachmentHandler)
+
+	// 5. Start the background jobs
+	reminders := scheduler.NewReminderScheduler(reminderService, todoService, notifier.FromEnv())
+	if interval, err := time.ParseDuration(os.Getenv("REMINDER_INTERVAL")); err == nil && interval > 0 {
+		reminders.Interval = interval
+	}
+	go reminders.Run(context.Background())
+
+	digests := scheduler.NewDigestScheduler(userService, digestService, notifier.MailerFromEnv())
+	if hour, err := strconv.Atoi(os.Getenv("DIGEST_HOUR")); err == nil && hour >= 0 && hour < 24 {
+		digests.Hour = hour
+	}
+	if os.Getenv("DIGEST_DISABLED") != "true" {
+		go digests.Run(context.Background())
+	}
+
+	purger := scheduler.NewTrashPurger(todoService)
+	if retention, err := time.ParseDuration(os.Getenv("TRASH_RETENTION")); err == nil && retention > 0 {
+		purger.Retention = retention
+	}
+	go purger.Run(context.Background())
+
+	archive := scheduler.NewArchivePolicy(todoService)
+	if after, err := time.ParseDuration(os.Getenv("ARCHIVE_AFTER")); err == nil && after > 0 {
+		archive.After = after
+	}
+	if os.Getenv("ARCHIVE_DISABLED") != "true" {
+		go archive.Run(context.Background())
+	}
+
+	go scheduler.NewRebalancer(todoService, subtaskService).Run(context.Background())
 
 	port := os.Getenv("PORT")
 	if port == "" {
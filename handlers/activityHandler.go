@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// todoState is a todo together with its details, taken before a change so
+// the change can be recorded afterwards
+type todoState struct {
+	todo    services.Todo
+	details *services.TodoDetails
+}
+
+func loadTodoState(todo services.Todo) todoState {
+	var detailsService services.TodoDetails
+	state := todoState{todo: todo}
+	details, err := detailsService.GetTodoDetailsByTodoId(todo.ID)
+	if err == nil && details.ID != "" {
+		state.details = &details
+	}
+	return state
+}
+
+// recordActivity records what action changed on a todo and its details
+// since before, userID is who made the change
+func recordActivity(todos services.Todo, userID primitive.ObjectID, action string, before todoState) {
+	todo, err := todos.GetTodoById(before.todo.ID)
+	if err != nil {
+		return
+	}
+	recordStates(todos, userID, action, before, loadTodoState(todo))
+}
+
+func recordStates(todos services.Todo, userID primitive.ObjectID, action string, before todoState, after todoState) {
+	todos.RecordActivity(userID, action, &before.todo, after.todo)
+	todoID, _ := primitive.ObjectIDFromHex(after.todo.ID)
+	todos.RecordDetailsActivity(userID, todoID, action, before.details, after.details)
+}
+
+// completionAction names a change of the completion state
+func completionAction(completed bool) string {
+	if completed {
+		return "completed"
+	}
+	return "reopened"
+}
+
+// List what happened to a todo latest first, ?limit= sets the page size and
+// ?after= takes the next cursor of the previous page
+func (h *TodoHandler) getActivity(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	limit := services.DefaultActivityPageSize
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > services.MaxActivityPageSize {
+			response.InvalidField(w, "limit", "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.Service.GetActivity(todo.ID, r.URL.Query().Get("after"), limit)
+	if errors.Is(err, services.ErrActivityNotFound) {
+		response.InvalidField(w, "after", "Invalid after cursor")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to load activity")
+		return
+	}
+
+	response.Data(w, 200, page)
+}
+
+// Put a todo, or its details, back the way they were right after an
+// activity
+func (h *TodoHandler) revertActivity(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err := h.Service.RevertToActivity(callerID(r), todo.ID, chi.URLParam(r, "activityId"), r.URL.Query().Get("force") == "true")
+	if errors.Is(err, services.ErrTodoBlocked) {
+		// The blockers may have been completed since, then the retry goes through
+		if checkBlockers(w, r, h.Service, todo) {
+			response.Error(w, 409, "Todo is blocked by open todos")
+		}
+		return
+	}
+	if errors.Is(err, services.ErrActivityNotFound) {
+		response.Error(w, 404, "Activity not found")
+		return
+	}
+	if errors.Is(err, services.ErrTodoNotFound) {
+		response.Error(w, 404, "Todo not found")
+		return
+	}
+	if errors.Is(err, services.ErrTodoDetailsTrashed) {
+		response.Error(w, 409, "Todo details are in the trash, restore them instead")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to revert todo")
+		return
+	}
+
+	before := todo
+	todo, err = h.Service.GetTodoById(todo.ID)
+	if err != nil {
+		response.Error(w, 500, "Failed to load todo")
+		return
+	}
+	if todo.Completed != before.Completed {
+		followCompletion(h.Service, h.DetailsService, callerID(r), todo)
+	}
+	response.Data(w, 200, h.withDetails(todo, h.tagsByID(callerID(r))))
+}
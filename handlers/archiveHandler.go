@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// archiveLocation is the timezone of ?timezone=, months of the archive
+// history are cut in it. Defaults to UTC.
+func archiveLocation(r *http.Request) (*time.Location, error) {
+	name := r.URL.Query().Get("timezone")
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+func writeArchiveError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrTodoNotFound):
+		response.Error(w, 404, "Todo not found")
+	case errors.Is(err, services.ErrTodoNotCompleted):
+		response.Error(w, 409, "Only completed todos can be archived")
+	default:
+		log.Println(err)
+		response.Error(w, 500, fallback)
+	}
+}
+
+func (h *TodoHandler) archiveTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err := h.Service.ArchiveTodo(callerID(r), todo.ID)
+	if err != nil {
+		writeArchiveError(w, err, "Failed to archive todo")
+		return
+	}
+	recordActivity(h.Service, callerID(r), "archived", loadTodoState(todo))
+
+	response.Message(w, 200, "Successfully Archived Todo")
+}
+
+func (h *TodoHandler) unarchiveTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err := h.Service.UnarchiveTodo(callerID(r), todo.ID)
+	if err != nil {
+		writeArchiveError(w, err, "Failed to unarchive todo")
+		return
+	}
+	recordActivity(h.Service, callerID(r), "unarchived", loadTodoState(todo))
+
+	response.Message(w, 200, "Successfully Unarchived Todo")
+}
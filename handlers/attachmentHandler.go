@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AttachmentHandler struct {
+	Service     services.Attachment
+	TodoService services.Todo
+}
+
+func NewAttachmentHandler(service services.Attachment, todoService services.Todo) *AttachmentHandler {
+	return &AttachmentHandler{
+		Service:     service,
+		TodoService: todoService,
+	}
+}
+
+func writeAttachmentError(w http.ResponseWriter, err error, fallback string) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrAttachmentNotFound):
+		response.Error(w, 404, "Attachment not found")
+	case errors.Is(err, services.ErrAttachmentTooLarge), errors.As(err, &tooLarge):
+		response.Error(w, 413, "Attachments can be up to 10 MB")
+	case errors.Is(err, services.ErrAttachmentType):
+		response.Error(w, 415, "Only images, PDFs and plain text can be attached")
+	default:
+		log.Println(err)
+		response.Error(w, 500, fallback)
+	}
+}
+
+func (h *AttachmentHandler) getAttachments(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	attachments, err := h.Service.GetAttachmentsByTodoId(todo.ID)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to load attachments")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		Items []services.Attachment `json:"items"`
+	}{
+		Items: attachments,
+	})
+}
+
+// Upload a file as multipart/form-data in the "file" field. The file is
+// streamed to the blob store, never held in memory as a whole.
+func (h *AttachmentHandler) createAttachment(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	// Room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxAttachmentSize+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		response.Error(w, 400, "Expected a multipart/form-data body")
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			response.InvalidField(w, "file", "Missing file field")
+			return
+		}
+		if err != nil {
+			writeAttachmentError(w, err, "Failed to read upload")
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		todoID, _ := primitive.ObjectIDFromHex(todo.ID)
+		entry := services.Attachment{TodoID: todoID, UserID: callerID(r), Filename: part.FileName()}
+		attachment, err := h.Service.InsertAttachment(r.Context(), entry, part)
+		part.Close()
+		if err != nil {
+			writeAttachmentError(w, err, "Failed to store attachment")
+			return
+		}
+
+		response.Created(w, apiBase+"/todos/"+todo.ID+"/attachments/"+attachment.ID, attachment)
+		return
+	}
+}
+
+// Stream an attachment, ?inline=true lets browsers show it instead of
+// downloading it
+func (h *AttachmentHandler) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	attachment, err := h.Service.GetAttachment(todo.ID, chi.URLParam(r, "attachmentId"))
+	if err != nil {
+		writeAttachmentError(w, err, "Failed to load attachment")
+		return
+	}
+	content, err := h.Service.OpenAttachment(r.Context(), attachment)
+	if err != nil {
+		writeAttachmentError(w, err, "Failed to load attachment")
+		return
+	}
+	defer content.Close()
+
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "true" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(200)
+	if _, err := io.Copy(w, content); err != nil {
+		log.Println(err)
+	}
+}
+
+func (h *AttachmentHandler) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err := h.Service.DeleteAttachment(todo.ID, chi.URLParam(r, "attachmentId"))
+	if err != nil {
+		writeAttachmentError(w, err, "Failed to delete attachment")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Deleted Attachment")
+}
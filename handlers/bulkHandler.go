@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// Bulk request structure, Op is applied to every todo of IDs. DateDue goes
+// with set_due_date, null clears it, Priority with set_priority and
+// ProjectID with move, null moves to the inbox.
+type BulkRequest struct {
+	IDs       []string `json:"ids"`
+	Op        string   `json:"op"`
+	DateDue   *string  `json:"date_due"`
+	Priority  string   `json:"priority"`
+	ProjectID *string  `json:"project_id"`
+}
+
+// Apply one operation to many of the caller's todos, every id gets its own
+// result. ?force=true completes blocked todos too.
+func (h *TodoHandler) bulkUpdateTodos(w http.ResponseWriter, r *http.Request) {
+	var req BulkRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if len(req.IDs) == 0 {
+		response.InvalidField(w, "ids", "ids is required")
+		return
+	}
+	if len(req.IDs) > services.MaxBulkTodos {
+		response.InvalidField(w, "ids", fmt.Sprintf("At most %d todos can be changed at once", services.MaxBulkTodos))
+		return
+	}
+
+	op := services.BulkOperation{Op: req.Op, Force: r.URL.Query().Get("force") == "true"}
+	switch req.Op {
+	case services.BulkSetDueDate:
+		if req.DateDue != nil {
+			dateDue, err := parseDateTime(*req.DateDue)
+			if err != nil {
+				response.InvalidField(w, "date_due", "Invalid date_due format. Use YYYY-MM-DD or ISO 8601 format")
+				return
+			}
+			op.DateDue = &dateDue
+		}
+	case services.BulkSetPriority:
+		priority, err := services.ParsePriority(req.Priority)
+		if err != nil {
+			response.InvalidField(w, "priority", err.Error())
+			return
+		}
+		op.Priority = priority
+	case services.BulkMove:
+		if req.ProjectID != nil {
+			projectID, ok := h.activeProject(w, r, *req.ProjectID)
+			if !ok {
+				return
+			}
+			op.ProjectID = &projectID
+		}
+	}
+
+	results, err := h.Service.BulkUpdateTodos(callerID(r), req.IDs, op)
+	if errors.Is(err, services.ErrUnknownBulkOperation) {
+		response.InvalidField(w, "op", "op must be one of complete, uncomplete, delete, set_due_date, set_priority, move")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to update todos")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		Items []services.BulkResult `json:"items"`
+	}{
+		Items: results,
+	})
+}
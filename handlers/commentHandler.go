@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CommentHandler struct {
+	Service     services.Comment
+	TodoService services.Todo
+}
+
+func NewCommentHandler(service services.Comment, todoService services.Todo) *CommentHandler {
+	return &CommentHandler{
+		Service:     service,
+		TodoService: todoService,
+	}
+}
+
+// Create Comment request structure, parent_id makes it a reply
+type CreateCommentRequest struct {
+	Body     string `json:"body"`
+	ParentID string `json:"parent_id"`
+}
+
+// Update Comment request structure
+type UpdateCommentRequest struct {
+	Body string `json:"body"`
+}
+
+func writeCommentError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrCommentNotFound):
+		response.Error(w, 404, "Comment not found")
+	case errors.Is(err, services.ErrNotCommentAuthor):
+		response.Error(w, 403, "Only the author can change a comment")
+	default:
+		log.Println(err)
+		response.Error(w, 500, fallback)
+	}
+}
+
+// validCommentBody answers 400 for an empty or too long body
+func validCommentBody(w http.ResponseWriter, body string) bool {
+	if strings.TrimSpace(body) == "" {
+		response.InvalidField(w, "body", "Comment body is required")
+		return false
+	}
+	if len(body) > services.MaxCommentLength {
+		response.InvalidField(w, "body", "Comment body is too long")
+		return false
+	}
+	return true
+}
+
+// List the comments of a todo oldest first, ?limit= sets the page size and
+// ?after= takes the next cursor of the previous page
+func (h *CommentHandler) getComments(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	limit := services.DefaultCommentPageSize
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > services.MaxCommentPageSize {
+			response.InvalidField(w, "limit", "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.Service.GetComments(todo.ID, r.URL.Query().Get("after"), limit)
+	if err != nil {
+		if errors.Is(err, services.ErrCommentNotFound) {
+			response.InvalidField(w, "after", "Invalid after cursor")
+			return
+		}
+		writeCommentError(w, err, "Failed to load comments")
+		return
+	}
+
+	response.Data(w, 200, page)
+}
+
+func (h *CommentHandler) getCommentByID(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	comment, err := h.Service.GetCommentById(todo.ID, chi.URLParam(r, "commentId"))
+	if err != nil {
+		writeCommentError(w, err, "Failed to load comment")
+		return
+	}
+
+	response.Data(w, 200, comment)
+}
+
+func (h *CommentHandler) createComment(w http.ResponseWriter, r *http.Request) {
+	var req CreateCommentRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if !validCommentBody(w, req.Body) {
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	todoID, _ := primitive.ObjectIDFromHex(todo.ID)
+
+	entry := services.Comment{TodoID: todoID, UserID: callerID(r), Body: req.Body}
+	if req.ParentID != "" {
+		parentID, err := primitive.ObjectIDFromHex(req.ParentID)
+		if err != nil {
+			response.Error(w, 404, "Comment not found")
+			return
+		}
+		entry.ParentID = &parentID
+	}
+
+	comment, err := h.Service.InsertComment(entry)
+	if err != nil {
+		writeCommentError(w, err, "Failed to create comment")
+		return
+	}
+
+	response.Created(w, apiBase+"/todos/"+todo.ID+"/comments/"+comment.ID, comment)
+}
+
+// Edit one of the caller's comments, the previous body stays in its history
+func (h *CommentHandler) updateComment(w http.ResponseWriter, r *http.Request) {
+	var req UpdateCommentRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if !validCommentBody(w, req.Body) {
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	comment, err := h.Service.UpdateComment(callerID(r), todo.ID, chi.URLParam(r, "commentId"), req.Body)
+	if err != nil {
+		writeCommentError(w, err, "Failed to update comment")
+		return
+	}
+
+	response.Data(w, 200, comment)
+}
+
+func (h *CommentHandler) deleteComment(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err := h.Service.DeleteComment(callerID(r), todo.ID, chi.URLParam(r, "commentId"))
+	if err != nil {
+		writeCommentError(w, err, "Failed to delete comment")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Deleted Comment")
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// RequireIfMatch refuses writes to todos and their details that come
+// without If-Match. Off by default, clients that do not send it keep
+// overwriting each other.
+var RequireIfMatch bool
+
+// entityTag is the ETag of a representation: the version of the document
+// it was read from and a hash of the body. The hash catches what the
+// version does not count, like subtask progress or the names of tags.
+func entityTag(version int64, body interface{}) string {
+	encoded, _ := json.Marshal(body)
+	sum := sha256.Sum256(encoded)
+	return fmt.Sprintf("\"%d-%x\"", version, sum[:8])
+}
+
+// matchesETag reports whether an If-Match or If-None-Match header lists
+// etag. weak ignores the W/ prefix, the way If-None-Match compares.
+func matchesETag(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag of a read and answers 304 Not Modified when
+// the client already has that representation
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !matchesETag(header, etag, true) {
+		return false
+	}
+	w.WriteHeader(304)
+	return true
+}
+
+// checkIfMatch compares If-Match with the ETag of what is about to be
+// written, writing 412 or 428 itself when the write cannot go ahead. It
+// returns the version the write has to find, so a change landing between
+// the check and the write is caught too.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, version int64, etag string) (int64, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if RequireIfMatch {
+			response.Error(w, 428, "If-Match header is required")
+			return 0, false
+		}
+		return services.AnyVersion, true
+	}
+	if !matchesETag(header, etag, false) {
+		response.Error(w, 412, "Changed since it was read, reload and try again")
+		return 0, false
+	}
+	return version, true
+}
+
+// writeVersionConflict answers a write that lost the race to another one
+func writeVersionConflict(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, services.ErrVersionMismatch) {
+		return false
+	}
+	response.Error(w, 412, "Changed since it was read, reload and try again")
+	return true
+}
+
+// todoETag is the ETag of a todo as GET /todos/{id} returns it
+func (h *TodoHandler) todoETag(r *http.Request, todo services.Todo) string {
+	return entityTag(todo.Version, h.withDetails(todo, h.tagsByID(callerID(r))))
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DependencyRef is a todo on the other side of a blocks / blocked by link
+type DependencyRef struct {
+	ID        string `json:"id"`
+	Task      string `json:"task"`
+	Completed bool   `json:"completed"`
+}
+
+// Add blocker request structure
+type AddBlockerRequest struct {
+	BlockerID string `json:"blocker_id"`
+}
+
+func dependencyRefs(todos []services.Todo) []DependencyRef {
+	refs := []DependencyRef{}
+	for _, todo := range todos {
+		refs = append(refs, DependencyRef{ID: todo.ID, Task: todo.Task, Completed: todo.Completed})
+	}
+	return refs
+}
+
+// dependencyRefs loads the todos blocking todo and the todos it blocks
+func (h *TodoHandler) dependencyRefs(todo services.Todo) ([]DependencyRef, []DependencyRef) {
+	blockedBy, err := h.Service.GetBlockers(todo)
+	if err != nil {
+		log.Println(err)
+	}
+	blocks, err := h.Service.GetBlocking(todo)
+	if err != nil {
+		log.Println(err)
+	}
+	return dependencyRefs(blockedBy), dependencyRefs(blocks)
+}
+
+// checkBlockers is asked before todo gets completed, it answers 409 with
+// the open blockers when todo has any. ?force=true completes it anyway.
+func checkBlockers(w http.ResponseWriter, r *http.Request, todos services.Todo, todo services.Todo) bool {
+	if r.URL.Query().Get("force") == "true" {
+		return true
+	}
+	open, err := todos.OpenBlockers(todo)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to check blockers")
+		return false
+	}
+	if len(open) == 0 {
+		return true
+	}
+	writeBlocked(w, open)
+	return false
+}
+
+// writeBlocked refuses to complete a todo that the open todos block
+func writeBlocked(w http.ResponseWriter, open []services.Todo) {
+	response.WriteProblem(w, response.Problem{
+		Type:       response.TypeTodoBlocked,
+		Title:      "Todo is blocked",
+		Status:     409,
+		Detail:     "Todo is blocked by open todos, complete them first or pass force=true",
+		Extensions: map[string]interface{}{"blockers": dependencyRefs(open)},
+	})
+}
+
+func writeDependencyError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrTodoNotFound):
+		response.Error(w, 404, "Todo not found")
+	case errors.Is(err, services.ErrSelfDependency):
+		response.InvalidField(w, "blocker_id", "A todo cannot block itself")
+	case errors.Is(err, services.ErrDependencyCycle):
+		response.Error(w, 409, "Blocker would create a dependency cycle")
+	default:
+		log.Println(err)
+		response.Error(w, 500, fallback)
+	}
+}
+
+// List the todos blocking a todo and the todos it blocks
+func (h *TodoHandler) getDependencies(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	blockedBy, blocks := h.dependencyRefs(todo)
+	response.Data(w, 200, struct {
+		BlockedBy []DependencyRef `json:"blocked_by"`
+		Blocks    []DependencyRef `json:"blocks"`
+	}{
+		BlockedBy: blockedBy,
+		Blocks:    blocks,
+	})
+}
+
+func (h *TodoHandler) addBlocker(w http.ResponseWriter, r *http.Request) {
+	var req AddBlockerRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err = h.Service.AddBlocker(callerID(r), todo.ID, req.BlockerID)
+	if err != nil {
+		writeDependencyError(w, err, "Failed to add blocker")
+		return
+	}
+	recordActivity(h.Service, callerID(r), "blocker_added", loadTodoState(todo))
+
+	response.Message(w, 200, "Successfully Added Blocker")
+}
+
+func (h *TodoHandler) removeBlocker(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err := h.Service.RemoveBlocker(callerID(r), todo.ID, chi.URLParam(r, "blockerId"))
+	if err != nil {
+		writeDependencyError(w, err, "Failed to remove blocker")
+		return
+	}
+	recordActivity(h.Service, callerID(r), "blocker_removed", loadTodoState(todo))
+
+	response.Message(w, 200, "Successfully Removed Blocker")
+}
+
+// List the todos of a project in an order that respects their dependencies
+func (h *TodoHandler) getProjectOrder(w http.ResponseWriter, r *http.Request) {
+	userID := callerID(r)
+
+	project, err := h.ProjectService.GetProjectById(userID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeProjectError(w, err, "Failed to load todos")
+		return
+	}
+	projectID, _ := primitive.ObjectIDFromHex(project.ID)
+
+	todos, err := h.Service.GetAllTodos(services.TodoFilter{UserID: userID, ProjectID: &projectID})
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to load todos")
+		return
+	}
+
+	items := h.withDetailsList(services.OrderByDependencies(todos), h.tagsByID(userID))
+
+	response.Data(w, 200, struct {
+		Items []TodoWithDetails `json:"items"`
+	}{
+		Items: items,
+	})
+}
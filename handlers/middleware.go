@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type contextKey string
+
+const callerKey contextKey = "caller"
+
+// requireCaller identifies the user making the request. Until authentication
+// is in place the frontend sends the signed in user's id in X-User-ID.
+func requireCaller(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := primitive.ObjectIDFromHex(r.Header.Get("X-User-ID"))
+		if err != nil {
+			response.Error(w, 401, "Missing or invalid X-User-ID header")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), callerKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// idempotentHeaders are the response headers replayed with a stored response
+var idempotentHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = 200
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// idempotent makes POST and PATCH requests sent with an Idempotency-Key
+// header safe to retry. The first request with a key runs and its response
+// is stored, retries with the same key and request get that response back
+// instead of running again. Must come after requireCaller, keys are per
+// user.
+func idempotent(next http.Handler) http.Handler {
+	var keys services.IdempotencyKey
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || (r.Method != "POST" && r.Method != "PATCH") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > services.MaxIdempotencyKeyLength {
+			response.InvalidField(w, "Idempotency-Key", "Idempotency-Key is too long")
+			return
+		}
+
+		userID := callerID(r)
+		stored, err := keys.ClaimIdempotencyKey(userID, key)
+		if errors.Is(err, services.ErrIdempotencyKeyInProgress) {
+			response.Error(w, 409, "A request with this Idempotency-Key is still in progress")
+			return
+		}
+		if err != nil {
+			response.Error(w, 500, "Failed to check Idempotency-Key")
+			return
+		}
+
+		// The body is hashed as the handler reads it, attachments are too
+		// big to hold on to
+		fingerprint := requestFingerprint(r)
+		if stored != nil {
+			io.Copy(fingerprint, r.Body)
+			if stored.MatchRequest(hex.EncodeToString(fingerprint.Sum(nil))) != nil {
+				response.WriteProblem(w, response.Problem{
+					Type:   response.TypeIdempotencyKey,
+					Title:  "Idempotency-Key was already used",
+					Status: 422,
+					Detail: "Idempotency-Key was already used for another request",
+				})
+				return
+			}
+			replayResponse(w, stored)
+			return
+		}
+
+		saved := false
+		defer func() {
+			if !saved {
+				keys.ReleaseIdempotencyKey(userID, key)
+			}
+		}()
+
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, fingerprint), r.Body}
+		recorder := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		io.Copy(io.Discard, r.Body)
+
+		// A failure on our side is not the answer to the request, the
+		// retry gets to run it again
+		if recorder.status >= 500 {
+			return
+		}
+		record := services.IdempotencyKey{
+			Fingerprint: hex.EncodeToString(fingerprint.Sum(nil)),
+			Status:      recorder.status,
+			Header:      map[string][]string{},
+			Body:        recorder.body.Bytes(),
+		}
+		for _, name := range idempotentHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				record.Header[name] = values
+			}
+		}
+		saved = keys.SaveIdempotentResponse(userID, key, record) == nil
+	})
+}
+
+// requestFingerprint starts the hash that tells requests made with the same
+// key apart, the body is still to be written to it
+func requestFingerprint(r *http.Request) hash.Hash {
+	fingerprint := sha256.New()
+	io.WriteString(fingerprint, r.Method+" "+r.URL.RequestURI()+"\n")
+	return fingerprint
+}
+
+// replayResponse writes a response stored for an idempotency key again
+func replayResponse(w http.ResponseWriter, stored *services.IdempotencyKey) {
+	for name, values := range stored.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// callerID returns the user resolved by requireCaller.
+func callerID(r *http.Request) primitive.ObjectID {
+	userID, _ := r.Context().Value(callerKey).(primitive.ObjectID)
+	return userID
+}
+
+// loadOwnedTodo loads the todo and checks it belongs to the caller, writing the
+// error response itself when it does not.
+func loadOwnedTodo(w http.ResponseWriter, r *http.Request, todos services.Todo, todoID string) (services.Todo, bool) {
+	if !primitive.IsValidObjectID(todoID) {
+		response.Error(w, 404, "Todo not found")
+		return services.Todo{}, false
+	}
+
+	todo, err := todos.GetTodoById(todoID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			response.Error(w, 404, "Todo not found")
+			return services.Todo{}, false
+		}
+		log.Println(err)
+		response.Error(w, 500, "Failed to load todo")
+		return services.Todo{}, false
+	}
+	if todo.UserID != callerID(r) {
+		response.Error(w, 403, "Todo belongs to another user")
+		return services.Todo{}, false
+	}
+	return todo, true
+}
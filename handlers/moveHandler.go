@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// Move request structure, the item goes right after AfterID and/or right
+// before BeforeID
+type MoveRequest struct {
+	AfterID  string `json:"after_id"`
+	BeforeID string `json:"before_id"`
+}
+
+// writeMoveError answers the errors every move shares, it reports false for
+// the others
+func writeMoveError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrNoNeighbor):
+		response.Invalid(w, "Give after_id or before_id",
+			response.FieldError{Field: "after_id", Detail: "after_id or before_id is required"},
+			response.FieldError{Field: "before_id", Detail: "after_id or before_id is required"},
+		)
+	case errors.Is(err, services.ErrNeighborNotFound):
+		response.Invalid(w, "Neighbor not found in the same list")
+	case errors.Is(err, services.ErrRankCollision):
+		response.Error(w, 409, "after_id has to come before before_id")
+	default:
+		return false
+	}
+	return true
+}
+
+// Move a todo between two todos of its project or inbox
+func (h *TodoHandler) moveTodo(w http.ResponseWriter, r *http.Request) {
+	var req MoveRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	before := todo
+	todo, err = h.Service.MoveTodo(callerID(r), todo.ID, req.AfterID, req.BeforeID)
+	if err != nil {
+		if writeMoveError(w, err) {
+			return
+		}
+		if errors.Is(err, services.ErrTodoNotFound) {
+			response.Error(w, 404, "Todo not found")
+			return
+		}
+		log.Println(err)
+		response.Error(w, 500, "Failed to move todo")
+		return
+	}
+	h.Service.RecordActivity(callerID(r), "reordered", &before, todo)
+
+	response.Data(w, 200, h.withDetails(todo, h.tagsByID(callerID(r))))
+}
+
+// Move a subtask between two other subtasks of its todo
+func (h *SubtaskHandler) moveSubtask(w http.ResponseWriter, r *http.Request) {
+	var req MoveRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	subtask, err := h.Service.MoveSubtask(todo.ID, chi.URLParam(r, "subtaskId"), req.AfterID, req.BeforeID)
+	if err != nil {
+		if !writeMoveError(w, err) {
+			writeSubtaskError(w, err, "Failed to move subtask")
+		}
+		return
+	}
+
+	response.Data(w, 200, subtask)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// Patch formats PATCH /todos/{id} accepts, a plain application/json body is
+// read as a merge patch
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+var errPatchTestFailed = errors.New("patch test operation failed")
+
+// jsonPatchOp is one operation of an RFC 6902 JSON Patch
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchView is how the patchable fields of a todo look in JSON, it is what
+// "test" operations compare against
+func patchView(todo services.Todo) map[string]interface{} {
+	date := func(t *time.Time) interface{} {
+		if t == nil {
+			return nil
+		}
+		return t.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"task":          todo.Task,
+		"date_start":    date(todo.DateStart),
+		"date_due":      date(todo.DateDue),
+		"completed":     todo.Completed,
+		"auto_complete": todo.AutoComplete,
+	}
+}
+
+// mergeFromJSONPatch turns the operations of a JSON Patch into the fields of
+// a merge patch. Only top level fields can be patched, which is all a todo
+// has.
+func mergeFromJSONPatch(body []byte, todo services.Todo) (map[string]json.RawMessage, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, errors.New("JSON Patch must be an array of operations")
+	}
+
+	current := patchView(todo)
+	fields := map[string]json.RawMessage{}
+	for _, op := range ops {
+		field := strings.TrimPrefix(op.Path, "/")
+		if !strings.HasPrefix(op.Path, "/") || strings.Contains(field, "/") {
+			return nil, fmt.Errorf("cannot patch path %q", op.Path)
+		}
+		switch op.Op {
+		case "add", "replace":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%s of %q needs a value", op.Op, op.Path)
+			}
+			fields[field] = op.Value
+			// Later tests see the value set here
+			var value interface{}
+			json.Unmarshal(op.Value, &value)
+			current[field] = value
+		case "remove":
+			fields[field] = json.RawMessage("null")
+			current[field] = nil
+		case "test":
+			var want interface{}
+			if err := json.Unmarshal(op.Value, &want); err != nil {
+				return nil, fmt.Errorf("test of %q needs a value", op.Path)
+			}
+			have, ok := current[field]
+			if !ok {
+				return nil, fmt.Errorf("cannot patch path %q", op.Path)
+			}
+			if !reflect.DeepEqual(have, want) {
+				return nil, errPatchTestFailed
+			}
+		default:
+			return nil, fmt.Errorf("unsupported operation %q", op.Op)
+		}
+	}
+	return fields, nil
+}
+
+// todoPatchFromFields reads the fields of a merge patch. Null clears a date,
+// fields that cannot be cleared or changed are refused.
+func todoPatchFromFields(fields map[string]json.RawMessage) (services.TodoPatch, *response.FieldError) {
+	var patch services.TodoPatch
+	for field, raw := range fields {
+		null := string(raw) == "null"
+		switch field {
+		case "task":
+			var task string
+			if null || json.Unmarshal(raw, &task) != nil || strings.TrimSpace(task) == "" {
+				return patch, &response.FieldError{Field: field, Detail: "task must be a non-empty string"}
+			}
+			patch.Task = &task
+		case "date_start", "date_due":
+			var date *time.Time
+			if !null {
+				var value string
+				if err := json.Unmarshal(raw, &value); err != nil {
+					return patch, &response.FieldError{Field: field, Detail: field + " must be a date or null"}
+				}
+				parsed, err := parseDateTime(value)
+				if err != nil {
+					return patch, &response.FieldError{Field: field, Detail: "Invalid " + field + " format. Use YYYY-MM-DD or ISO 8601 format"}
+				}
+				date = &parsed
+			}
+			if field == "date_start" {
+				patch.DateStart, patch.ClearDateStart = date, null
+			} else {
+				patch.DateDue, patch.ClearDateDue = date, null
+			}
+		case "completed", "auto_complete":
+			var value bool
+			if null || json.Unmarshal(raw, &value) != nil {
+				return patch, &response.FieldError{Field: field, Detail: field + " must be true or false"}
+			}
+			if field == "completed" {
+				patch.Completed = &value
+			} else {
+				patch.AutoComplete = &value
+			}
+		default:
+			return patch, &response.FieldError{Field: field, Detail: field + " cannot be patched"}
+		}
+	}
+	return patch, nil
+}
+
+// Update only the fields given, as an RFC 7396 merge patch or an RFC 6902
+// JSON Patch
+func (h *TodoHandler) patchTodo(w http.ResponseWriter, r *http.Request) {
+	before, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	expected, ok := checkIfMatch(w, r, before.Version, h.todoETag(r, before))
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case jsonPatchType:
+		fields, err = mergeFromJSONPatch(body, before)
+	case mergePatchType, "application/json", "":
+		if json.Unmarshal(body, &fields) != nil || fields == nil {
+			err = errors.New("Merge patch must be a JSON object")
+		}
+	default:
+		response.Error(w, 415, "Use application/merge-patch+json or application/json-patch+json")
+		return
+	}
+	if errors.Is(err, errPatchTestFailed) {
+		response.Error(w, 409, "Todo does not match the patch test")
+		return
+	}
+	if err != nil {
+		response.Invalid(w, err.Error())
+		return
+	}
+
+	patch, invalid := todoPatchFromFields(fields)
+	if invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+	after := patch.Apply(before)
+	if after.DateStart != nil && after.DateDue != nil && after.DateStart.After(*after.DateDue) {
+		response.InvalidField(w, "date_start", "Start date cannot be after due date")
+		return
+	}
+	if after.Completed && !before.Completed && !checkBlockers(w, r, h.Service, after) {
+		return
+	}
+
+	state := loadTodoState(before)
+	todo, err := h.Service.PatchTodo(before.ID, patch, expected)
+	if writeVersionConflict(w, err) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to update todo")
+		return
+	}
+
+	if patch.DateDue != nil || patch.ClearDateDue {
+		if err := h.ReminderService.RescheduleReminders(todo.ID, todo.DateDue); err != nil {
+			log.Println(err)
+		}
+	}
+	recordActivity(h.Service, callerID(r), "updated", state)
+	if todo.Completed != before.Completed {
+		followCompletion(h.Service, h.DetailsService, callerID(r), todo)
+	}
+
+	h.writeTodo(w, r, todo)
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+func patchTestTodo() services.Todo {
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	return services.Todo{Task: "Write report", DateDue: &due, AutoComplete: true}
+}
+
+// applyMergePatch applies a merge patch body the way patchTodo does
+func applyMergePatch(t *testing.T, body string, todo services.Todo) services.Todo {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		t.Fatal(err)
+	}
+	patch, invalid := todoPatchFromFields(fields)
+	if invalid != nil {
+		t.Fatalf("todoPatchFromFields(%s): %s", body, invalid.Detail)
+	}
+	return patch.Apply(todo)
+}
+
+func TestMergePatch(t *testing.T) {
+	todo := patchTestTodo()
+
+	got := applyMergePatch(t, `{"task": "Write the report", "completed": true}`, todo)
+	if got.Task != "Write the report" || !got.Completed {
+		t.Errorf("fields not set: %+v", got)
+	}
+	if got.DateDue == nil || !got.DateDue.Equal(*todo.DateDue) || !got.AutoComplete {
+		t.Errorf("fields left out of the patch changed: %+v", got)
+	}
+
+	got = applyMergePatch(t, `{"date_due": null, "date_start": "2026-02-01"}`, todo)
+	if got.DateDue != nil {
+		t.Errorf("date_due not cleared: %v", got.DateDue)
+	}
+	if got.DateStart == nil || got.DateStart.Format("2006-01-02") != "2026-02-01" {
+		t.Errorf("date_start = %v", got.DateStart)
+	}
+
+	got = applyMergePatch(t, `{}`, todo)
+	if got.Task != todo.Task || got.DateDue != todo.DateDue {
+		t.Errorf("empty patch changed the todo: %+v", got)
+	}
+}
+
+func TestMergePatchRejects(t *testing.T) {
+	tests := []struct {
+		body  string
+		field string
+	}{
+		{`{"task": ""}`, "task"},
+		{`{"task": null}`, "task"},
+		{`{"task": 5}`, "task"},
+		{`{"date_due": "tomorrow"}`, "date_due"},
+		{`{"date_start": 20260101}`, "date_start"},
+		{`{"completed": null}`, "completed"},
+		{`{"auto_complete": "yes"}`, "auto_complete"},
+		{`{"user_id": "someone"}`, "user_id"},
+	}
+	for _, tt := range tests {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tt.body), &fields); err != nil {
+			t.Fatal(err)
+		}
+		_, invalid := todoPatchFromFields(fields)
+		if invalid == nil {
+			t.Errorf("%s: accepted", tt.body)
+			continue
+		}
+		if invalid.Field != tt.field {
+			t.Errorf("%s: field = %q, want %q", tt.body, invalid.Field, tt.field)
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	todo := patchTestTodo()
+	tests := []struct {
+		name string
+		body string
+		want func(services.Todo) bool
+	}{
+		{
+			name: "replace",
+			body: `[{"op": "replace", "path": "/task", "value": "Write the report"}]`,
+			want: func(got services.Todo) bool { return got.Task == "Write the report" },
+		},
+		{
+			name: "remove clears",
+			body: `[{"op": "remove", "path": "/date_due"}]`,
+			want: func(got services.Todo) bool { return got.DateDue == nil },
+		},
+		{
+			name: "add sets",
+			body: `[{"op": "add", "path": "/completed", "value": true}]`,
+			want: func(got services.Todo) bool { return got.Completed },
+		},
+		{
+			name: "test passes against the stored todo",
+			body: `[{"op": "test", "path": "/task", "value": "Write report"}, {"op": "replace", "path": "/auto_complete", "value": false}]`,
+			want: func(got services.Todo) bool { return !got.AutoComplete },
+		},
+		{
+			name: "test sees earlier operations",
+			body: `[{"op": "replace", "path": "/task", "value": "New"}, {"op": "test", "path": "/task", "value": "New"}]`,
+			want: func(got services.Todo) bool { return got.Task == "New" },
+		},
+		{
+			name: "test of a date",
+			body: `[{"op": "test", "path": "/date_due", "value": "2026-03-01T09:00:00Z"}, {"op": "remove", "path": "/date_due"}]`,
+			want: func(got services.Todo) bool { return got.DateDue == nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := mergeFromJSONPatch([]byte(tt.body), todo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patch, invalid := todoPatchFromFields(fields)
+			if invalid != nil {
+				t.Fatal(invalid.Detail)
+			}
+			if got := patch.Apply(todo); !tt.want(got) {
+				t.Errorf("unexpected todo %+v", got)
+			}
+		})
+	}
+}
+
+func TestJSONPatchRejects(t *testing.T) {
+	todo := patchTestTodo()
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not an array", `{"op": "replace"}`},
+		{"nested path", `[{"op": "replace", "path": "/task/0", "value": "x"}]`},
+		{"relative path", `[{"op": "replace", "path": "task", "value": "x"}]`},
+		{"replace without value", `[{"op": "replace", "path": "/task"}]`},
+		{"unsupported op", `[{"op": "move", "from": "/task", "path": "/date_due"}]`},
+		{"test of unknown field", `[{"op": "test", "path": "/rank", "value": "i"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := mergeFromJSONPatch([]byte(tt.body), todo); err == nil || errors.Is(err, errPatchTestFailed) {
+				t.Errorf("error = %v, want a refused patch", err)
+			}
+		})
+	}
+
+	body := `[{"op": "test", "path": "/task", "value": "Something else"}, {"op": "remove", "path": "/date_due"}]`
+	if _, err := mergeFromJSONPatch([]byte(body), todo); !errors.Is(err, errPatchTestFailed) {
+		t.Errorf("failed test: error = %v, want errPatchTestFailed", err)
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultProjectColor = "#6366f1"
+
+type ProjectHandler struct {
+	Service services.Project
+}
+
+func NewProjectHandler(service services.Project) *ProjectHandler {
+	return &ProjectHandler{
+		Service: service,
+	}
+}
+
+// Create and update Project request structure
+type ProjectRequest struct {
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Icon     string `json:"icon"`
+	Archived bool   `json:"archived"`
+}
+
+type ReorderProjectsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// validate trims the name and fills in the default color
+func (req *ProjectRequest) validate() *response.FieldError {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return &response.FieldError{Field: "name", Detail: "Project name is required"}
+	}
+	if len(req.Name) > 100 {
+		return &response.FieldError{Field: "name", Detail: "Project name must be at most 100 characters"}
+	}
+	if req.Color == "" {
+		req.Color = defaultProjectColor
+	}
+	if !hexColorPattern.MatchString(req.Color) {
+		return &response.FieldError{Field: "color", Detail: "Project color must be a hex color like #ff8800"}
+	}
+	if len(req.Icon) > 32 {
+		return &response.FieldError{Field: "icon", Detail: "Project icon must be at most 32 characters"}
+	}
+	return nil
+}
+
+// writeProjectError maps project service errors to responses
+func writeProjectError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrProjectNotFound):
+		response.Error(w, 404, "Project not found")
+	case errors.Is(err, services.ErrProjectNotEmpty):
+		response.Error(w, 409, "Project still has todos, pass todos=move or todos=delete")
+	default:
+		log.Println(err)
+		response.Error(w, 500, fallback)
+	}
+}
+
+// List projects, archived ones only with ?archived=true
+func (h *ProjectHandler) getProjects(w http.ResponseWriter, r *http.Request) {
+	includeArchived := r.URL.Query().Get("archived") == "true"
+
+	projects, err := h.Service.GetProjectsByUser(callerID(r), includeArchived)
+	if err != nil {
+		writeProjectError(w, err, "Failed to load projects")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		Items []services.Project `json:"items"`
+	}{Items: projects})
+}
+
+func (h *ProjectHandler) getProjectByID(w http.ResponseWriter, r *http.Request) {
+	project, err := h.Service.GetProjectById(callerID(r), chi.URLParam(r, "id"))
+	if err != nil {
+		writeProjectError(w, err, "Failed to load project")
+		return
+	}
+
+	response.Data(w, 200, project)
+}
+
+func (h *ProjectHandler) createProject(w http.ResponseWriter, r *http.Request) {
+	var req ProjectRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if invalid := req.validate(); invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+
+	project, err := h.Service.InsertProject(services.Project{
+		UserID: callerID(r),
+		Name:   req.Name,
+		Color:  req.Color,
+		Icon:   req.Icon,
+	})
+	if err != nil {
+		writeProjectError(w, err, "Failed to create project")
+		return
+	}
+
+	response.Created(w, apiBase+"/projects/"+project.ID, project)
+}
+
+// Update name, color, icon or archive the project
+func (h *ProjectHandler) updateProject(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req ProjectRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if invalid := req.validate(); invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+
+	err = h.Service.UpdateProject(callerID(r), id, services.Project{
+		Name:     req.Name,
+		Color:    req.Color,
+		Icon:     req.Icon,
+		Archived: req.Archived,
+	})
+	if err != nil {
+		writeProjectError(w, err, "Failed to update project")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Updated Project")
+}
+
+// Store the order of the projects in the sidebar
+func (h *ProjectHandler) reorderProjects(w http.ResponseWriter, r *http.Request) {
+	var req ReorderProjectsRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	err = h.Service.ReorderProjects(callerID(r), req.IDs)
+	if err != nil {
+		writeProjectError(w, err, "Failed to reorder projects")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Reordered Projects")
+}
+
+// Delete a project. A project with todos needs ?todos=move (optionally
+// with &target=<project id>, the inbox otherwise) or ?todos=delete
+func (h *ProjectHandler) deleteProject(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID := callerID(r)
+	mode := r.URL.Query().Get("todos")
+
+	if mode != "" && mode != services.ProjectTodosMove && mode != services.ProjectTodosDelete {
+		response.InvalidField(w, "todos", "todos must be move or delete")
+		return
+	}
+
+	var target *primitive.ObjectID
+	if targetID := r.URL.Query().Get("target"); mode == services.ProjectTodosMove && targetID != "" {
+		project, err := h.Service.GetProjectById(userID, targetID)
+		if err != nil {
+			writeProjectError(w, err, "Failed to delete project")
+			return
+		}
+		mongoID, _ := primitive.ObjectIDFromHex(project.ID)
+		target = &mongoID
+	}
+
+	result, err := h.Service.DeleteProject(userID, id, mode, target)
+	if errors.Is(err, services.ErrProjectMoveIntoSelf) {
+		response.InvalidField(w, "target", "Cannot move todos into the project being deleted")
+		return
+	}
+	if err != nil {
+		writeProjectError(w, err, "Failed to delete project")
+		return
+	}
+
+	response.Data(w, 200, result)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// Set recurrence request structure
+type RecurrenceRequest struct {
+	RRule    string `json:"rrule"`
+	Timezone string `json:"timezone"`
+}
+
+// recurrenceScope reads ?scope=, which defaults to this occurrence only
+func recurrenceScope(w http.ResponseWriter, r *http.Request) (string, bool) {
+	scope := r.URL.Query().Get("scope")
+	switch scope {
+	case "":
+		return services.ScopeThis, true
+	case services.ScopeThis, services.ScopeFuture:
+		return scope, true
+	}
+	response.InvalidField(w, "scope", "scope must be this or future")
+	return "", false
+}
+
+// Preview the next occurrences of a repeating todo, ?count= up to 50
+func (h *TodoHandler) getOccurrences(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	if todo.Recurrence == nil {
+		response.Error(w, 400, "Todo does not repeat")
+		return
+	}
+
+	count := 10
+	if value := r.URL.Query().Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 50 {
+			response.InvalidField(w, "count", "count must be between 1 and 50")
+			return
+		}
+		count = n
+	}
+
+	after := time.Now()
+	if at := todo.OccurrenceTime(); at != nil {
+		after = *at
+	}
+	occurrences, err := todo.Recurrence.Upcoming(after, count)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to compute occurrences")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		Items []time.Time `json:"items"`
+	}{
+		Items: occurrences,
+	})
+}
+
+// Set the rule of a todo. With scope=future the later open occurrences of
+// its series follow, otherwise the todo starts a series of its own.
+func (h *TodoHandler) setRecurrence(w http.ResponseWriter, r *http.Request) {
+	var req RecurrenceRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	scope, ok := recurrenceScope(w, r)
+	if !ok {
+		return
+	}
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	at := todo.OccurrenceTime()
+	if at == nil {
+		response.InvalidField(w, "rrule", "A repeating todo needs date_start or date_due")
+		return
+	}
+	rec, err := services.NewRecurrence(req.RRule, req.Timezone, *at)
+	if err != nil {
+		response.InvalidField(w, "rrule", err.Error())
+		return
+	}
+
+	if scope == services.ScopeFuture && todo.Recurrence != nil {
+		_, err = h.Service.SplitSeries(todo, rec)
+	} else {
+		err = h.Service.SetTodoRecurrence(todo.UserID, todo.ID, rec)
+	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to update recurrence")
+		return
+	}
+	recordActivity(h.Service, callerID(r), "recurrence_changed", loadTodoState(todo))
+
+	response.Message(w, 200, "Successfully Updated Recurrence")
+}
+
+// Stop a todo repeating. With scope=future its later open occurrences stop
+// too, earlier ones are left alone.
+func (h *TodoHandler) deleteRecurrence(w http.ResponseWriter, r *http.Request) {
+	scope, ok := recurrenceScope(w, r)
+	if !ok {
+		return
+	}
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	if todo.Recurrence == nil {
+		response.Error(w, 400, "Todo does not repeat")
+		return
+	}
+
+	var err error
+	if scope == services.ScopeFuture {
+		_, err = h.Service.SplitSeries(todo, nil)
+	} else {
+		err = h.Service.SetTodoRecurrence(todo.UserID, todo.ID, nil)
+	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to remove recurrence")
+		return
+	}
+	recordActivity(h.Service, callerID(r), "recurrence_changed", loadTodoState(todo))
+
+	response.Message(w, 200, "Successfully Removed Recurrence")
+}
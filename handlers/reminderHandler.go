@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+type ReminderHandler struct {
+	Service     services.Reminder
+	TodoService services.Todo
+}
+
+func NewReminderHandler(service services.Reminder, todoService services.Todo) *ReminderHandler {
+	return &ReminderHandler{
+		Service:     service,
+		TodoService: todoService,
+	}
+}
+
+// Create Reminder request structure, either remind_at or offset_minutes
+// before the due date
+type ReminderRequest struct {
+	RemindAt      string `json:"remind_at"`
+	OffsetMinutes *int   `json:"offset_minutes"`
+}
+
+func (h *ReminderHandler) getReminders(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	reminders, err := h.Service.GetRemindersByTodoId(todo.ID)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to load reminders")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		Items []services.Reminder `json:"items"`
+	}{
+		Items: reminders,
+	})
+}
+
+func (h *ReminderHandler) getReminderByID(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	reminder, err := h.Service.GetReminderById(todo.ID, chi.URLParam(r, "reminderId"))
+	if errors.Is(err, services.ErrReminderNotFound) {
+		response.Error(w, 404, "Reminder not found")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to load reminder")
+		return
+	}
+
+	response.Data(w, 200, reminder)
+}
+
+func (h *ReminderHandler) createReminder(w http.ResponseWriter, r *http.Request) {
+	var req ReminderRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if (req.RemindAt == "") == (req.OffsetMinutes == nil) {
+		response.Invalid(w, "Set either remind_at or offset_minutes",
+			response.FieldError{Field: "remind_at", Detail: "Set either remind_at or offset_minutes"},
+			response.FieldError{Field: "offset_minutes", Detail: "Set either remind_at or offset_minutes"},
+		)
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	entry := services.Reminder{OffsetMinutes: req.OffsetMinutes}
+	if req.RemindAt != "" {
+		remindAt, err := parseDateTime(req.RemindAt)
+		if err != nil {
+			response.InvalidField(w, "remind_at", "Invalid remind_at format. Use YYYY-MM-DD or ISO 8601 format")
+			return
+		}
+		entry.RemindAt = &remindAt
+	} else {
+		if *req.OffsetMinutes < 0 {
+			response.InvalidField(w, "offset_minutes", "offset_minutes cannot be negative")
+			return
+		}
+		if todo.DateDue == nil {
+			response.InvalidField(w, "offset_minutes", "An offset reminder needs a todo with date_due")
+			return
+		}
+	}
+
+	reminder, err := h.Service.InsertReminder(todo, entry)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to create reminder")
+		return
+	}
+
+	response.Created(w, apiBase+"/todos/"+todo.ID+"/reminders/"+reminder.ID, reminder)
+}
+
+func (h *ReminderHandler) deleteReminder(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err := h.Service.DeleteReminder(todo.ID, chi.URLParam(r, "reminderId"))
+	if err != nil {
+		if errors.Is(err, services.ErrReminderNotFound) {
+			response.Error(w, 404, "Reminder not found")
+			return
+		}
+		log.Println(err)
+		response.Error(w, 500, "Failed to delete reminder")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Deleted Reminder")
+}
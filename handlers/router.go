@@ -1,27 +1,35 @@
 package handlers
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
+	"github.com/yogisyo16/root-aura-service/response"
 )
 
-type Response struct {
-	Msg  string
-	Code int
-}
+// apiBase is where the routes of CreateRouter are mounted
+const apiBase = "/api/v1"
 
-func CreateRouter(todoHandler *TodoHandler, userHandler *UserHandler, todoTodoDetailsHandler *TodoDetailsHandler) *chi.Mux {
+func CreateRouter(todoHandler *TodoHandler, userHandler *UserHandler, todoTodoDetailsHandler *TodoDetailsHandler, tagHandler *TagHandler, projectHandler *ProjectHandler, subtaskHandler *SubtaskHandler, reminderHandler *ReminderHandler, commentHandler *CommentHandler, attachmentHandler *AttachmentHandler) *chi.Mux {
 	router := chi.NewRouter()
 
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CRSF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CRSF-Token", "X-User-ID", "If-Match", "If-None-Match", "Idempotency-Key"},
+		ExposedHeaders:   []string{"Link", "ETag", "Location", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		response.Error(w, 404, "No route for "+r.URL.Path)
+	})
+	router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		response.Error(w, 405, r.Method+" is not allowed on "+r.URL.Path)
+	})
+
 	router.Route("/api", func(router chi.Router) {
 		router.Route("/v1", func(router chi.Router) {
 			// User Routes
@@ -29,19 +37,110 @@ func CreateRouter(todoHandler *TodoHandler, userHandler *UserHandler, todoTodoDe
 			router.Get("/users", userHandler.getAllUsers)
 			router.Get("/users/{id}", userHandler.getUserByID)
 
-			// Todo Routes
 			router.Get("/healthcheck", HealthCheck)
-			router.Get("/todos", todoHandler.getTodos)
-			router.Get("/todos/{id}", todoHandler.getTodoByID)
-			router.Post("/todos/create", todoHandler.createTodo)
-			router.Put("/todos/update/{id}", todoHandler.updateTodo)
-			router.Patch("/todos/{id}/complete", todoHandler.toggleComplete)
-			router.Delete("/todos/delete/{id}", todoHandler.deleteTodo)
-
-			// Todo Details Routes
-			router.Get("/todos/tododetails", todoTodoDetailsHandler.getTodoDetails)
-			router.Post("/todos/tododetails/create", todoTodoDetailsHandler.createTodoDetails)
-			router.Delete("/todos/tododetails/delete/{id}", todoTodoDetailsHandler.deleteTodoDetails)
+
+			router.Group(func(router chi.Router) {
+				router.Use(requireCaller)
+				router.Use(idempotent)
+
+				// Preference and digest routes of the caller
+				router.Put("/users/preferences", userHandler.updatePreferences)
+				router.Get("/users/digest/preview", userHandler.previewDigest)
+
+				// Todo Routes
+				router.Get("/todos", todoHandler.getTodos)
+				router.Get("/todos/{id}", todoHandler.getTodoByID)
+				router.Post("/todos/create", todoHandler.createTodo)
+				router.Post("/todos/bulk", todoHandler.bulkUpdateTodos)
+				router.Put("/todos/update/{id}", todoHandler.updateTodo)
+				router.Patch("/todos/{id}", todoHandler.patchTodo)
+				router.Patch("/todos/{id}/complete", todoHandler.toggleComplete)
+				router.Post("/todos/{id}/complete", todoHandler.completeTodo)
+				router.Post("/todos/{id}/uncomplete", todoHandler.uncompleteTodo)
+				router.Post("/todos/{id}/archive", todoHandler.archiveTodo)
+				router.Post("/todos/{id}/unarchive", todoHandler.unarchiveTodo)
+				router.Post("/todos/{id}/move", todoHandler.moveTodo)
+				router.Delete("/todos/delete/{id}", todoHandler.deleteTodo)
+				router.Get("/todos/{id}/details", todoTodoDetailsHandler.getTodoDetailsByTodoID)
+				router.Put("/todos/{id}/details", todoTodoDetailsHandler.replaceTodoDetails)
+				router.Patch("/todos/{id}/details", todoTodoDetailsHandler.patchTodoDetails)
+
+				// Todo Details Routes
+				router.Get("/todos/tododetails", todoTodoDetailsHandler.getTodoDetails)
+				router.Post("/todos/tododetails/create", todoTodoDetailsHandler.createTodoDetails)
+				router.Delete("/todos/tododetails/delete/{id}", todoTodoDetailsHandler.deleteTodoDetails)
+				router.Get("/tododetails/workflow", todoTodoDetailsHandler.getWorkflow)
+				router.Get("/tododetails/{id}", todoTodoDetailsHandler.getTodoDetailsByID)
+
+				// Tag Routes
+				router.Get("/tags", tagHandler.getTags)
+				router.Get("/tags/summary", tagHandler.getTagSummary)
+				router.Post("/tags/create", tagHandler.createTag)
+				router.Get("/tags/{id}", tagHandler.getTagByID)
+				router.Put("/tags/update/{id}", tagHandler.updateTag)
+				router.Post("/tags/{id}/merge", tagHandler.mergeTag)
+				router.Delete("/tags/delete/{id}", tagHandler.deleteTag)
+				router.Put("/todos/{id}/tags", tagHandler.setTodoTags)
+
+				// Project Routes
+				router.Get("/projects", projectHandler.getProjects)
+				router.Get("/projects/{id}", projectHandler.getProjectByID)
+				router.Get("/projects/{id}/todos", todoHandler.getProjectTodos)
+				router.Get("/projects/{id}/order", todoHandler.getProjectOrder)
+				router.Post("/projects/create", projectHandler.createProject)
+				router.Put("/projects/update/{id}", projectHandler.updateProject)
+				router.Put("/projects/reorder", projectHandler.reorderProjects)
+				router.Delete("/projects/delete/{id}", projectHandler.deleteProject)
+				router.Put("/todos/{id}/project", todoHandler.setTodoProject)
+
+				// Subtask Routes
+				router.Get("/todos/{id}/subtasks", subtaskHandler.getSubtasks)
+				router.Post("/todos/{id}/subtasks/create", subtaskHandler.createSubtask)
+				router.Get("/todos/{id}/subtasks/{subtaskId}", subtaskHandler.getSubtaskByID)
+				router.Put("/todos/{id}/subtasks/update/{subtaskId}", subtaskHandler.updateSubtask)
+				router.Put("/todos/{id}/subtasks/reorder", subtaskHandler.reorderSubtasks)
+				router.Post("/todos/{id}/subtasks/{subtaskId}/move", subtaskHandler.moveSubtask)
+				router.Patch("/todos/{id}/subtasks/{subtaskId}/complete", subtaskHandler.toggleSubtask)
+				router.Delete("/todos/{id}/subtasks/delete/{subtaskId}", subtaskHandler.deleteSubtask)
+
+				// Dependency Routes
+				router.Get("/todos/{id}/dependencies", todoHandler.getDependencies)
+				router.Post("/todos/{id}/blockers", todoHandler.addBlocker)
+				router.Delete("/todos/{id}/blockers/{blockerId}", todoHandler.removeBlocker)
+
+				// Reminder Routes
+				router.Get("/todos/{id}/reminders", reminderHandler.getReminders)
+				router.Post("/todos/{id}/reminders/create", reminderHandler.createReminder)
+				router.Get("/todos/{id}/reminders/{reminderId}", reminderHandler.getReminderByID)
+				router.Delete("/todos/{id}/reminders/delete/{reminderId}", reminderHandler.deleteReminder)
+
+				// Recurrence Routes, ?scope=this|future picks the occurrences an edit applies to
+				router.Get("/todos/{id}/occurrences", todoHandler.getOccurrences)
+				router.Put("/todos/{id}/recurrence", todoHandler.setRecurrence)
+				router.Delete("/todos/{id}/recurrence", todoHandler.deleteRecurrence)
+
+				// Activity Routes
+				router.Get("/todos/{id}/activity", todoHandler.getActivity)
+				router.Post("/todos/{id}/activity/{activityId}/revert", todoHandler.revertActivity)
+
+				// Comment Routes
+				router.Get("/todos/{id}/comments", commentHandler.getComments)
+				router.Post("/todos/{id}/comments/create", commentHandler.createComment)
+				router.Get("/todos/{id}/comments/{commentId}", commentHandler.getCommentByID)
+				router.Put("/todos/{id}/comments/update/{commentId}", commentHandler.updateComment)
+				router.Delete("/todos/{id}/comments/delete/{commentId}", commentHandler.deleteComment)
+
+				// Attachment Routes
+				router.Get("/todos/{id}/attachments", attachmentHandler.getAttachments)
+				router.Post("/todos/{id}/attachments/create", attachmentHandler.createAttachment)
+				router.Get("/todos/{id}/attachments/{attachmentId}", attachmentHandler.downloadAttachment)
+				router.Delete("/todos/{id}/attachments/delete/{attachmentId}", attachmentHandler.deleteAttachment)
+
+				// Trash Routes
+				router.Get("/trash", todoHandler.getTrash)
+				router.Post("/trash/{id}/restore", todoHandler.restoreFromTrash)
+				router.Delete("/trash/{id}", todoHandler.purgeTrashItem)
+			})
 		})
 
 		router.Route("/v2", func(router chi.Router) {
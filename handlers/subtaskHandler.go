@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SubtaskHandler struct {
+	Service        services.Subtask
+	TodoService    services.Todo
+	DetailsService services.TodoDetails
+}
+
+func NewSubtaskHandler(service services.Subtask, todoService services.Todo, detailsService services.TodoDetails) *SubtaskHandler {
+	return &SubtaskHandler{
+		Service:        service,
+		TodoService:    todoService,
+		DetailsService: detailsService,
+	}
+}
+
+// Create and update Subtask request structure
+type SubtaskRequest struct {
+	Title string `json:"title"`
+}
+
+type ReorderSubtasksRequest struct {
+	IDs []string `json:"ids"`
+}
+
+func (req *SubtaskRequest) validate() *response.FieldError {
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" {
+		return &response.FieldError{Field: "title", Detail: "Subtask title is required"}
+	}
+	if len(req.Title) > 500 {
+		return &response.FieldError{Field: "title", Detail: "Subtask title must be at most 500 characters"}
+	}
+	return nil
+}
+
+// writeSubtaskError maps subtask service errors to responses
+func writeSubtaskError(w http.ResponseWriter, err error, fallback string) {
+	if errors.Is(err, services.ErrSubtaskNotFound) {
+		response.Error(w, 404, "Subtask not found")
+		return
+	}
+	log.Println(err)
+	response.Error(w, 500, fallback)
+}
+
+// syncParent completes or reopens a todo with auto_complete once its
+// subtasks changed, it returns the new progress. Open blockers keep the
+// todo from being completed.
+func (h *SubtaskHandler) syncParent(todo services.Todo) services.SubtaskProgress {
+	progress, err := h.Service.GetSubtaskProgress(todo.ID)
+	if err != nil {
+		log.Println(err)
+		return progress
+	}
+	if !todo.AutoComplete || todo.Completed == progress.AllDone() {
+		return progress
+	}
+
+	if progress.AllDone() {
+		open, err := h.TodoService.OpenBlockers(todo)
+		if err != nil || len(open) > 0 {
+			return progress
+		}
+	}
+
+	state := loadTodoState(todo)
+	if err := h.TodoService.SetTodoCompleted(todo.ID, progress.AllDone()); err != nil {
+		log.Println("Could not auto complete todo: ", err)
+		return progress
+	}
+	recordActivity(h.TodoService, todo.UserID, completionAction(progress.AllDone()), state)
+	if after, err := h.TodoService.GetTodoById(todo.ID); err == nil {
+		followCompletion(h.TodoService, h.DetailsService, todo.UserID, after)
+	}
+	return progress
+}
+
+func (h *SubtaskHandler) getSubtasks(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	subtasks, err := h.Service.GetSubtasksByTodoId(todo.ID)
+	if err != nil {
+		writeSubtaskError(w, err, "Failed to load subtasks")
+		return
+	}
+
+	done := int64(0)
+	for _, subtask := range subtasks {
+		if subtask.Completed {
+			done++
+		}
+	}
+
+	response.Data(w, 200, struct {
+		Items    []services.Subtask       `json:"items"`
+		Progress services.SubtaskProgress `json:"progress"`
+	}{
+		Items:    subtasks,
+		Progress: services.SubtaskProgress{Done: done, Total: int64(len(subtasks))},
+	})
+}
+
+func (h *SubtaskHandler) getSubtaskByID(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	subtask, err := h.Service.GetSubtaskById(todo.ID, chi.URLParam(r, "subtaskId"))
+	if err != nil {
+		writeSubtaskError(w, err, "Failed to load subtask")
+		return
+	}
+
+	response.Data(w, 200, subtask)
+}
+
+func (h *SubtaskHandler) createSubtask(w http.ResponseWriter, r *http.Request) {
+	var req SubtaskRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if invalid := req.validate(); invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	todoID, _ := primitive.ObjectIDFromHex(todo.ID)
+
+	subtask, err := h.Service.InsertSubtask(services.Subtask{
+		TodoID: todoID,
+		Title:  req.Title,
+	})
+	if err != nil {
+		writeSubtaskError(w, err, "Failed to create subtask")
+		return
+	}
+	h.syncParent(todo)
+
+	response.Created(w, apiBase+"/todos/"+todo.ID+"/subtasks/"+subtask.ID, subtask)
+}
+
+func (h *SubtaskHandler) updateSubtask(w http.ResponseWriter, r *http.Request) {
+	var req SubtaskRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if invalid := req.validate(); invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err = h.Service.UpdateSubtaskTitle(todo.ID, chi.URLParam(r, "subtaskId"), req.Title)
+	if err != nil {
+		writeSubtaskError(w, err, "Failed to update subtask")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Updated Subtask")
+}
+
+// Toggle a subtask, the todo is completed or reopened with it when it has
+// auto_complete set
+func (h *SubtaskHandler) toggleSubtask(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	subtask, err := h.Service.ToggleSubtask(todo.ID, chi.URLParam(r, "subtaskId"))
+	if err != nil {
+		writeSubtaskError(w, err, "Failed to toggle subtask")
+		return
+	}
+	progress := h.syncParent(todo)
+
+	response.Data(w, 200, struct {
+		Subtask  services.Subtask         `json:"subtask"`
+		Progress services.SubtaskProgress `json:"progress"`
+	}{
+		Subtask:  subtask,
+		Progress: progress,
+	})
+}
+
+// Store the order of the subtasks of a todo
+func (h *SubtaskHandler) reorderSubtasks(w http.ResponseWriter, r *http.Request) {
+	var req ReorderSubtasksRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err = h.Service.ReorderSubtasks(todo.ID, req.IDs)
+	if err != nil {
+		writeSubtaskError(w, err, "Failed to reorder subtasks")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Reordered Subtasks")
+}
+
+func (h *SubtaskHandler) deleteSubtask(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	err := h.Service.DeleteSubtask(todo.ID, chi.URLParam(r, "subtaskId"))
+	if err != nil {
+		writeSubtaskError(w, err, "Failed to delete subtask")
+		return
+	}
+	h.syncParent(todo)
+
+	response.Message(w, 200, "Successfully Deleted Subtask")
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultTagColor = "#9ca3af"
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+type TagHandler struct {
+	Service     services.Tag
+	TodoService services.Todo
+}
+
+func NewTagHandler(service services.Tag, todoService services.Todo) *TagHandler {
+	return &TagHandler{
+		Service:     service,
+		TodoService: todoService,
+	}
+}
+
+// Create and update Tag request structure
+type TagRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type MergeTagRequest struct {
+	TargetID string `json:"target_id"`
+}
+
+type SetTodoTagsRequest struct {
+	TagIDs []string `json:"tag_ids"`
+}
+
+// validate trims the name and fills in the default color
+func (req *TagRequest) validate() *response.FieldError {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return &response.FieldError{Field: "name", Detail: "Tag name is required"}
+	}
+	if len(req.Name) > 50 {
+		return &response.FieldError{Field: "name", Detail: "Tag name must be at most 50 characters"}
+	}
+	if req.Color == "" {
+		req.Color = defaultTagColor
+	}
+	if !hexColorPattern.MatchString(req.Color) {
+		return &response.FieldError{Field: "color", Detail: "Tag color must be a hex color like #ff8800"}
+	}
+	return nil
+}
+
+// writeTagError maps tag service errors to responses
+func writeTagError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrTagNotFound):
+		response.Error(w, 404, "Tag not found")
+	case errors.Is(err, services.ErrTagExists):
+		response.Error(w, 409, "A tag with this name already exists")
+	case errors.Is(err, services.ErrTodoNotFound):
+		response.Error(w, 404, "Todo not found")
+	default:
+		log.Println(err)
+		response.Error(w, 500, fallback)
+	}
+}
+
+func (h *TagHandler) getTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.Service.GetTagsByUser(callerID(r))
+	if err != nil {
+		writeTagError(w, err, "Failed to load tags")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		Items []services.Tag `json:"items"`
+	}{Items: tags})
+}
+
+func (h *TagHandler) getTagByID(w http.ResponseWriter, r *http.Request) {
+	tag, err := h.Service.GetTagById(callerID(r), chi.URLParam(r, "id"))
+	if err != nil {
+		writeTagError(w, err, "Failed to load tag")
+		return
+	}
+
+	response.Data(w, 200, tag)
+}
+
+// Todo counts per tag for the sidebar
+func (h *TagHandler) getTagSummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := h.Service.GetTagSummary(callerID(r))
+	if err != nil {
+		writeTagError(w, err, "Failed to load tag summary")
+		return
+	}
+
+	response.Data(w, 200, summary)
+}
+
+func (h *TagHandler) createTag(w http.ResponseWriter, r *http.Request) {
+	var req TagRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if invalid := req.validate(); invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+
+	tag, err := h.Service.InsertTag(services.Tag{
+		UserID: callerID(r),
+		Name:   req.Name,
+		Color:  req.Color,
+	})
+	if err != nil {
+		writeTagError(w, err, "Failed to create tag")
+		return
+	}
+
+	response.Created(w, apiBase+"/tags/"+tag.ID, tag)
+}
+
+// Rename or recolor a tag
+func (h *TagHandler) updateTag(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req TagRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if invalid := req.validate(); invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+
+	err = h.Service.UpdateTag(callerID(r), id, services.Tag{
+		Name:  req.Name,
+		Color: req.Color,
+	})
+	if err != nil {
+		writeTagError(w, err, "Failed to update tag")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Updated Tag")
+}
+
+// Merge the tag into target_id, its todos keep the target tag
+func (h *TagHandler) mergeTag(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req MergeTagRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	moved, err := h.Service.MergeTags(callerID(r), id, req.TargetID)
+	if errors.Is(err, services.ErrTagMergeSelf) {
+		response.InvalidField(w, "target_id", "Cannot merge a tag into itself")
+		return
+	}
+	if err != nil {
+		writeTagError(w, err, "Failed to merge tags")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		TargetID     string `json:"target_id"`
+		TodosUpdated int64  `json:"todos_updated"`
+	}{
+		TargetID:     req.TargetID,
+		TodosUpdated: moved,
+	})
+}
+
+// Delete the tag and remove it from every todo
+func (h *TagHandler) deleteTag(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	untagged, err := h.Service.DeleteTag(callerID(r), id)
+	if err != nil {
+		writeTagError(w, err, "Failed to delete tag")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		TagID        string `json:"tag_id"`
+		TodosUpdated int64  `json:"todos_updated"`
+	}{
+		TagID:        id,
+		TodosUpdated: untagged,
+	})
+}
+
+// Replace the tags of a todo
+func (h *TagHandler) setTodoTags(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req SetTodoTagsRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	seen := map[primitive.ObjectID]bool{}
+	tagIDs := []primitive.ObjectID{}
+	for _, hex := range req.TagIDs {
+		tagID, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			response.InvalidField(w, "tag_ids", "Invalid tag id "+hex)
+			return
+		}
+		if !seen[tagID] {
+			seen[tagID] = true
+			tagIDs = append(tagIDs, tagID)
+		}
+	}
+
+	// Only the caller's own tags can be assigned
+	if len(tagIDs) > 0 {
+		count, err := h.Service.CountTagsByUser(callerID(r), tagIDs)
+		if err != nil {
+			writeTagError(w, err, "Failed to tag todo")
+			return
+		}
+		if count != int64(len(tagIDs)) {
+			response.Error(w, 404, "Tag not found")
+			return
+		}
+	}
+
+	before, ok := loadOwnedTodo(w, r, h.TodoService, id)
+	if !ok {
+		return
+	}
+
+	err = h.TodoService.SetTodoTags(callerID(r), id, tagIDs)
+	if err != nil {
+		writeTagError(w, err, "Failed to tag todo")
+		return
+	}
+	recordActivity(h.TodoService, callerID(r), "tags_changed", loadTodoState(before))
+
+	response.Message(w, 200, "Successfully Updated Todo Tags")
+}
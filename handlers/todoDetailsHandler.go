@@ -2,20 +2,26 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
 	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type TodoDetailsHandler struct {
-	Service services.TodoDetails
+	Service     services.TodoDetails
+	TodoService services.Todo
 }
 
-func NewTodoDetailsHandler(service services.TodoDetails) *TodoDetailsHandler {
+func NewTodoDetailsHandler(service services.TodoDetails, todoService services.Todo) *TodoDetailsHandler {
 	return &TodoDetailsHandler{
-		Service: service,
+		Service:     service,
+		TodoService: todoService,
 	}
 }
 
@@ -27,46 +33,244 @@ type CreateTodoDetailsRequest struct {
 	PriorityDetails string `json:"priority_details"`
 }
 
+// Update Todo Details request structure, omitted fields are left untouched
+// by PATCH and cleared by PUT
+type UpdateTodoDetailsRequest struct {
+	TaskDetails     *string `json:"task_details"`
+	NotesDetails    *string `json:"notes_details"`
+	StatusDetails   *string `json:"status_details"`
+	PriorityDetails *string `json:"priority_details"`
+}
+
+// normalizeDetails validates status and priority, filling in the defaults
+// for a todo with the given completion state. It returns the field at fault.
+func normalizeDetails(details *services.TodoDetails, completed bool) *response.FieldError {
+	if details.StatusDetails == "" {
+		details.StatusDetails = services.StatusTodo
+		if completed {
+			details.StatusDetails = services.StatusDone
+		}
+	}
+	if details.PriorityDetails == "" {
+		details.PriorityDetails = services.PriorityMedium
+	}
+
+	status, err := services.ParseStatus(details.StatusDetails)
+	if err != nil {
+		return &response.FieldError{Field: "status_details", Detail: err.Error()}
+	}
+	priority, err := services.ParsePriority(details.PriorityDetails)
+	if err != nil {
+		return &response.FieldError{Field: "priority_details", Detail: err.Error()}
+	}
+	details.StatusDetails = status
+	details.PriorityDetails = priority
+	return nil
+}
+
+// syncCompletion completes or reopens the todo when its details moved into
+// or out of the done status.
+func (h *TodoDetailsHandler) syncCompletion(r *http.Request, todo services.Todo, status string) {
+	completed := status == services.StatusDone
+	if todo.Completed == completed {
+		return
+	}
+	if err := h.TodoService.SetTodoCompleted(todo.ID, completed); err != nil {
+		log.Println("Could not sync todo completion: ", err)
+		return
+	}
+	if todo, err := h.TodoService.GetTodoById(todo.ID); err == nil {
+		followCompletion(h.TodoService, h.Service, callerID(r), todo)
+	}
+}
+
+// completing tells whether details moving to status complete the todo,
+// which open blockers prevent. It answers 409 itself when they do.
+func (h *TodoDetailsHandler) completing(w http.ResponseWriter, r *http.Request, todo services.Todo, status string) bool {
+	if status != services.StatusDone || todo.Completed {
+		return true
+	}
+	return checkBlockers(w, r, h.TodoService, todo)
+}
+
 func (h *TodoDetailsHandler) getTodoDetails(w http.ResponseWriter, r *http.Request) {
-	todoDetails, err := h.Service.GetAllTodosDetails()
+	todoDetails, err := h.Service.GetAllTodosDetails(callerID(r))
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(500)
+		response.Error(w, 500, "Failed to load todo details")
 		return
 	}
 
-	response := struct {
-		Code int `json:"code"`
-		Data struct {
-			Items []services.TodoDetails `json:"items"`
-		} `json:"data"`
+	response.Data(w, 200, struct {
+		Items []services.TodoDetails `json:"items"`
 	}{
-		Code: 200,
-		Data: struct {
-			Items []services.TodoDetails `json:"items"`
-		}{
-			Items: todoDetails,
-		},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(response)
+		Items: todoDetails,
+	})
 }
 
 func (h *TodoDetailsHandler) getTodoDetailsByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
+	if !primitive.IsValidObjectID(id) {
+		response.Error(w, 404, "Todo details not found")
+		return
+	}
+
 	todoDetail, err := h.Service.GetTodoDetailsById(id)
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(404)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			response.Error(w, 404, "Todo details not found")
+			return
+		}
+		response.Error(w, 500, "Failed to load todo details")
+		return
+	}
+
+	if _, ok := loadOwnedTodo(w, r, h.TodoService, todoDetail.TodoID.Hex()); !ok {
+		return
+	}
+	if notModified(w, r, entityTag(todoDetail.Version, todoDetail)) {
+		return
+	}
+
+	response.Data(w, 200, todoDetail)
+}
+
+// Statuses, priorities and status transitions the API accepts
+func (h *TodoDetailsHandler) getWorkflow(w http.ResponseWriter, r *http.Request) {
+	response.Data(w, 200, struct {
+		Statuses    []string            `json:"statuses"`
+		Priorities  []string            `json:"priorities"`
+		Transitions map[string][]string `json:"transitions"`
+	}{
+		Statuses:    services.Statuses,
+		Priorities:  services.Priorities,
+		Transitions: services.AllowedTransitions(),
+	})
+}
+
+// Get the details of a todo
+func (h *TodoDetailsHandler) getTodoDetailsByTodoID(w http.ResponseWriter, r *http.Request) {
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	todoDetail, err := h.Service.GetTodoDetailsByTodoId(todo.ID)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to load todo details")
+		return
+	}
+	if todoDetail.ID == "" {
+		response.Error(w, 404, "Todo has no details")
+		return
+	}
+	if notModified(w, r, entityTag(todoDetail.Version, todoDetail)) {
+		return
+	}
+
+	response.Data(w, 200, todoDetail)
+}
+
+// PUT replaces every editable field of the todo details
+func (h *TodoDetailsHandler) replaceTodoDetails(w http.ResponseWriter, r *http.Request) {
+	h.updateTodoDetails(w, r, true)
+}
+
+// PATCH only changes the fields present in the request body
+func (h *TodoDetailsHandler) patchTodoDetails(w http.ResponseWriter, r *http.Request) {
+	h.updateTodoDetails(w, r, false)
+}
+
+func (h *TodoDetailsHandler) updateTodoDetails(w http.ResponseWriter, r *http.Request, replace bool) {
+	var req UpdateTodoDetailsRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	if !replace && req.TaskDetails == nil && req.NotesDetails == nil &&
+		req.StatusDetails == nil && req.PriorityDetails == nil {
+		response.Invalid(w, "Nothing to update")
+		return
+	}
+
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	current, err := h.Service.GetTodoDetailsByTodoId(todo.ID)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to load todo details")
+		return
+	}
+	if current.ID == "" {
+		response.Error(w, 404, "Todo has no details")
+		return
+	}
+	expected, ok := checkIfMatch(w, r, current.Version, entityTag(current.Version, current))
+	if !ok {
+		return
+	}
+
+	updated := current
+	if replace {
+		updated = services.TodoDetails{}
+	}
+	if req.TaskDetails != nil {
+		updated.TaskDetails = *req.TaskDetails
+	}
+	if req.NotesDetails != nil {
+		updated.NotesDetails = *req.NotesDetails
+	}
+	if req.StatusDetails != nil {
+		updated.StatusDetails = *req.StatusDetails
+	}
+	if req.PriorityDetails != nil {
+		updated.PriorityDetails = *req.PriorityDetails
+	}
+
+	if invalid := normalizeDetails(&updated, todo.Completed); invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+
+	// Details stored before the workflow existed may hold any status
+	from, _ := services.ParseStatus(current.StatusDetails)
+	if !services.CanTransition(from, updated.StatusDetails) {
+		response.Error(w, 409, "Cannot move todo from "+from+" to "+updated.StatusDetails)
+		return
+	}
+	if !h.completing(w, r, todo, updated.StatusDetails) {
+		return
+	}
+
+	state := todoState{todo: todo, details: &current}
+	err = h.Service.UpdateTodoDetails(current.ID, updated, expected)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		response.Error(w, 404, "Todo has no details")
+		return
+	}
+	if writeVersionConflict(w, err) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to update todo details")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(todoDetail)
+	h.syncCompletion(r, todo, updated.StatusDetails)
+	recordActivity(h.TodoService, callerID(r), "details_updated", state)
+
+	response.Message(w, 200, "Successfully Updated Todo Details")
 }
 
 func (h *TodoDetailsHandler) createTodoDetails(w http.ResponseWriter, r *http.Request) {
@@ -75,68 +279,99 @@ func (h *TodoDetailsHandler) createTodoDetails(w http.ResponseWriter, r *http.Re
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		log.Println(err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Invalid request body",
-			Code: 400,
-		})
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	todoID, err := primitive.ObjectIDFromHex(req.TodoID)
+	if err != nil {
+		response.InvalidField(w, "todo_id", "Invalid todo_id")
+		return
+	}
+
+	// The parent todo must exist and belong to the caller
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, req.TodoID)
+	if !ok {
 		return
 	}
 
 	newTodoDetails := services.TodoDetails{
-		TodoID:          req.TodoID,
+		TodoID:          todoID,
 		TaskDetails:     req.TaskDetails,
 		NotesDetails:    req.NotesDetails,
 		StatusDetails:   req.StatusDetails,
 		PriorityDetails: req.PriorityDetails,
 	}
 
-	err = h.Service.InsertTodoDetails(newTodoDetails)
+	if invalid := normalizeDetails(&newTodoDetails, todo.Completed); invalid != nil {
+		response.InvalidField(w, invalid.Field, invalid.Detail)
+		return
+	}
+	if !h.completing(w, r, todo, newTodoDetails.StatusDetails) {
+		return
+	}
+
+	state := todoState{todo: todo}
+	details, err := h.Service.InsertTodoDetails(newTodoDetails)
+	if errors.Is(err, services.ErrTodoNotFound) {
+		response.Error(w, 404, "Todo not found")
+		return
+	}
+	if errors.Is(err, services.ErrTodoDetailsExists) {
+		response.Error(w, 409, "Todo already has details, update them instead")
+		return
+	}
+	if errors.Is(err, services.ErrTodoDetailsTrashed) {
+		response.Error(w, 409, "Todo details are in the trash, restore them instead")
+		return
+	}
 	if err != nil {
 		log.Println(err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Failed to create todo details",
-			Code: 500,
-		})
+		response.Error(w, 500, "Failed to create todo details")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-	json.NewEncoder(w).Encode(Response{
-		Msg:  "Successfully Created Todo Details",
-		Code: 201,
-	})
+	h.syncCompletion(r, todo, newTodoDetails.StatusDetails)
+	recordActivity(h.TodoService, callerID(r), "details_created", state)
+
+	w.Header().Set("ETag", entityTag(details.Version, details))
+	response.Created(w, apiBase+"/tododetails/"+details.ID, details)
 }
 
 func (h *TodoDetailsHandler) deleteTodoDetails(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	err := h.Service.DeleteTodoDetails(id)
+	// The todo the details belong to has to be the caller's
+	details, err := h.Service.GetTodoDetailsById(id)
 	if err != nil {
-		errorRes := Response{
-			Msg:  "Error",
-			Code: 304,
-		}
-		json.NewEncoder(w).Encode(errorRes)
-		w.WriteHeader(errorRes.Code)
+		response.Error(w, 404, "Todo details not found")
 		return
 	}
-
-	res := Response{
-		Msg:  "Succesfully Deleted Todo",
-		Code: 200,
+	todo, ok := loadOwnedTodo(w, r, h.TodoService, details.TodoID.Hex())
+	if !ok {
+		return
 	}
+	expected, ok := checkIfMatch(w, r, details.Version, entityTag(details.Version, details))
+	if !ok {
+		return
+	}
+	state := todoState{todo: todo, details: &details}
 
-	jsonStr, err := json.Marshal(res)
+	err = h.Service.DeleteTodoDetails(id, expected)
+	if writeVersionConflict(w, err) {
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		response.Error(w, 404, "Todo details not found")
+		return
+	}
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		response.Error(w, 500, "Failed to delete todo details")
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(res.Code)
-	w.Write(jsonStr)
+	recordActivity(h.TodoService, callerID(r), "details_deleted", state)
+
+	response.Message(w, 200, "Successfully Deleted Todo Details")
 }
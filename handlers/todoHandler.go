@@ -2,79 +2,296 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
 	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type TodoHandler struct {
-	Service        services.Todo
-	DetailsService services.TodoDetails // Add this
+	Service         services.Todo
+	DetailsService  services.TodoDetails // Add this
+	TagService      services.Tag
+	ProjectService  services.Project
+	SubtaskService  services.Subtask
+	ReminderService services.Reminder
+	CommentService  services.Comment
 }
 
 // Create Todo request structure
 type CreateTodoRequest struct {
-	Task      string `json:"task"`
-	DateStart string `json:"date_start"`
-	DateDue   string `json:"date_due"`
-	Completed bool   `json:"completed"`
+	Task         string `json:"task"`
+	DateStart    string `json:"date_start"`
+	DateDue      string `json:"date_due"`
+	Completed    bool   `json:"completed"`
+	AutoComplete bool   `json:"auto_complete"`
+	ProjectID    string `json:"project_id"`
+	// RRule makes the todo repeat, evaluated in Timezone (UTC by default)
+	RRule    string `json:"rrule"`
+	Timezone string `json:"timezone"`
+}
+
+// Move Todo request structure, a null project_id moves it to the inbox
+type SetTodoProjectRequest struct {
+	ProjectID *string `json:"project_id"`
 }
 
 // Update Todo request structure
 type UpdateTodoRequest struct {
-	Task      string `json:"task"`
-	DateStart string `json:"date_start"`
-	DateDue   string `json:"date_due"`
-	Completed bool   `json:"completed"`
+	Task         string `json:"task"`
+	DateStart    string `json:"date_start"`
+	DateDue      string `json:"date_due"`
+	Completed    bool   `json:"completed"`
+	AutoComplete bool   `json:"auto_complete"`
 }
 
 type TodoWithDetails struct {
-	ID          string                `json:"id,omitempty"`
-	UserID      string                `json:"user_id"`
-	Task        string                `json:"task"`
-	DateStart   *time.Time            `json:"date_start"`
-	DateDue     *time.Time            `json:"date_due"`
-	Completed   bool                  `json:"completed"`
-	TodoDetails *services.TodoDetails `json:"todo_details"`
-	CreatedAt   time.Time             `json:"created_at,omitempty"`
-	UpdatedAt   time.Time             `json:"update_at,omitempty"`
+	ID           string                   `json:"id,omitempty"`
+	UserID       primitive.ObjectID       `json:"user_id"`
+	Task         string                   `json:"task"`
+	DateStart    *time.Time               `json:"date_start"`
+	DateDue      *time.Time               `json:"date_due"`
+	Completed    bool                     `json:"completed"`
+	CompletedAt  *time.Time               `json:"completed_at"`
+	ArchivedAt   *time.Time               `json:"archived_at"`
+	AutoComplete bool                     `json:"auto_complete"`
+	ProjectID    *primitive.ObjectID      `json:"project_id"`
+	Recurrence   *services.Recurrence     `json:"recurrence"`
+	BlockedBy    []DependencyRef          `json:"blocked_by"`
+	Blocks       []DependencyRef          `json:"blocks"`
+	Tags         []services.Tag           `json:"tags"`
+	Subtasks     services.SubtaskProgress `json:"subtasks"`
+	CommentCount int64                    `json:"comment_count"`
+	Rank         string                   `json:"rank"`
+	TodoDetails  *services.TodoDetails    `json:"todo_details"`
+	CreatedAt    time.Time                `json:"created_at,omitempty"`
+	UpdatedAt    time.Time                `json:"updated_at,omitempty"`
+	Version      int64                    `json:"version"`
 }
 
 // Generic response structure
-func NewTodoHandler(service services.Todo, detailsService services.TodoDetails) *TodoHandler {
+func NewTodoHandler(service services.Todo, detailsService services.TodoDetails, tagService services.Tag, projectService services.Project, subtaskService services.Subtask, reminderService services.Reminder, commentService services.Comment) *TodoHandler {
 	return &TodoHandler{
-		Service:        service,
-		DetailsService: detailsService, // Initialize this
+		Service:         service,
+		DetailsService:  detailsService, // Initialize this
+		TagService:      tagService,
+		ProjectService:  projectService,
+		SubtaskService:  subtaskService,
+		ReminderService: reminderService,
+		CommentService:  commentService,
 	}
 }
 
 // Health Check endpoint
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
-	res := Response{
-		Msg:  "Health Check",
-		Code: 200,
+	response.Message(w, 200, "Health Check")
+}
+
+// todoRelations holds what the API representation of a page of todos
+// shows besides the todos, each keyed by todo id
+type todoRelations struct {
+	details   map[string]services.TodoDetails
+	subtasks  map[string]services.SubtaskProgress
+	comments  map[string]int64
+	blockedBy map[string][]services.Todo
+	blocks    map[string][]services.Todo
+}
+
+// loadRelations loads the relations of todos with one query per kind, not
+// per todo. What fails to load is left out.
+func (h *TodoHandler) loadRelations(todos []services.Todo) todoRelations {
+	ids := make([]string, len(todos))
+	for i, todo := range todos {
+		ids[i] = todo.ID
+	}
+
+	var relations todoRelations
+	var err error
+	if relations.details, err = h.DetailsService.GetTodoDetailsByTodoIds(ids); err != nil {
+		log.Println(err)
+	}
+	if relations.subtasks, err = h.SubtaskService.GetSubtaskProgresses(ids); err != nil {
+		log.Println(err)
+	}
+	if relations.comments, err = h.CommentService.CountCommentsByTodo(ids); err != nil {
+		log.Println(err)
+	}
+	if relations.blockedBy, relations.blocks, err = h.Service.GetDependencies(todos); err != nil {
+		log.Println(err)
+	}
+	return relations
+}
+
+// withDetails builds the API representation of a todo. tags holds the
+// caller's tags keyed by id.
+func (h *TodoHandler) withDetails(todo services.Todo, tags map[string]services.Tag) TodoWithDetails {
+	return h.loadRelations([]services.Todo{todo}).view(todo, tags)
+}
+
+// withDetailsList builds the API representation of a page of todos
+func (h *TodoHandler) withDetailsList(todos []services.Todo, tags map[string]services.Tag) []TodoWithDetails {
+	relations := h.loadRelations(todos)
+	items := []TodoWithDetails{}
+	for _, todo := range todos {
+		items = append(items, relations.view(todo, tags))
+	}
+	return items
+}
+
+func (relations todoRelations) view(todo services.Todo, tags map[string]services.Tag) TodoWithDetails {
+	todoWithDetails := TodoWithDetails{
+		ID:           todo.ID,
+		UserID:       todo.UserID,
+		Task:         todo.Task,
+		DateStart:    todo.DateStart,
+		DateDue:      todo.DateDue,
+		Completed:    todo.Completed,
+		CompletedAt:  todo.CompletedAt,
+		ArchivedAt:   todo.ArchivedAt,
+		Rank:         todo.Rank,
+		AutoComplete: todo.AutoComplete,
+		ProjectID:    todo.ProjectID,
+		Recurrence:   todo.Recurrence,
+		Tags:         []services.Tag{},
+		CreatedAt:    todo.CreatedAt,
+		UpdatedAt:    todo.UpdatedAt,
+		Version:      todo.Version,
+		TodoDetails:  nil, // Default to nil (will show as null in JSON)
+	}
+
+	for _, tagID := range todo.TagIDs {
+		if tag, ok := tags[tagID.Hex()]; ok {
+			todoWithDetails.Tags = append(todoWithDetails.Tags, tag)
+		}
 	}
 
-	jsonStr, err := json.Marshal(res)
+	// Try to get the details for this todo
+	if details, ok := relations.details[todo.ID]; ok {
+		todoWithDetails.TodoDetails = &details
+	}
+	todoWithDetails.Subtasks = relations.subtasks[todo.ID]
+	todoWithDetails.CommentCount = relations.comments[todo.ID]
+	todoWithDetails.BlockedBy = dependencyRefs(relations.blockedBy[todo.ID])
+	todoWithDetails.Blocks = dependencyRefs(relations.blocks[todo.ID])
+
+	return todoWithDetails
+}
+
+// writeTodo responds with the todo the way GET /todos/{id} shows it,
+// together with its ETag
+func (h *TodoHandler) writeTodo(w http.ResponseWriter, r *http.Request, todo services.Todo) {
+	view := h.withDetails(todo, h.tagsByID(callerID(r)))
+	w.Header().Set("ETag", entityTag(todo.Version, view))
+	response.Data(w, 200, view)
+}
+
+// tagsByID loads the caller's tags keyed by id
+func (h *TodoHandler) tagsByID(userID primitive.ObjectID) map[string]services.Tag {
+	tags, err := h.TagService.GetTagsByUser(userID)
 	if err != nil {
 		log.Println(err)
-		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(res.Code)
-	w.Write(jsonStr)
+
+	byID := make(map[string]services.Tag, len(tags))
+	for _, tag := range tags {
+		byID[tag.ID] = tag
+	}
+	return byID
 }
 
-// Logic to get all todos
+// Logic to get all todos of the caller
 // Updated with details todos included
+// Filter by project with ?project_id=<id> or ?project_id=inbox
 func (h *TodoHandler) getTodos(w http.ResponseWriter, r *http.Request) {
-	todos, err := h.Service.GetAllTodos()
+	filter := services.TodoFilter{UserID: callerID(r)}
+
+	switch projectID := r.URL.Query().Get("project_id"); projectID {
+	case "":
+	case "inbox":
+		filter.Inbox = true
+	default:
+		project, err := h.ProjectService.GetProjectById(filter.UserID, projectID)
+		if err != nil {
+			writeProjectError(w, err, "Failed to load todos")
+			return
+		}
+		mongoID, _ := primitive.ObjectIDFromHex(project.ID)
+		filter.ProjectID = &mongoID
+	}
+
+	// Archived todos are only listed with ?archived=true, ?month=2026-01
+	// narrows them to one month of the history
+	if r.URL.Query().Get("archived") == "true" {
+		filter.Archived = true
+		if month := r.URL.Query().Get("month"); month != "" {
+			loc, err := archiveLocation(r)
+			if err != nil {
+				response.InvalidField(w, "timezone", "Invalid timezone")
+				return
+			}
+			from, err := time.ParseInLocation("2006-01", month, loc)
+			if err != nil {
+				response.InvalidField(w, "month", "Invalid month, expected YYYY-MM")
+				return
+			}
+			to := from.AddDate(0, 1, 0)
+			filter.CompletedFrom, filter.CompletedTo = &from, &to
+		}
+	}
+
+	h.listTodos(w, r, filter)
+}
+
+// Logic to get the todos of one project
+func (h *TodoHandler) getProjectTodos(w http.ResponseWriter, r *http.Request) {
+	userID := callerID(r)
+
+	project, err := h.ProjectService.GetProjectById(userID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeProjectError(w, err, "Failed to load todos")
+		return
+	}
+	mongoID, _ := primitive.ObjectIDFromHex(project.ID)
+
+	h.listTodos(w, r, services.TodoFilter{UserID: userID, ProjectID: &mongoID})
+}
+
+// listTodos writes the todos matching filter
+// Filter by tag name with ?tag=work&tag=urgent, todos need every tag unless
+// tag_mode=any is given
+func (h *TodoHandler) listTodos(w http.ResponseWriter, r *http.Request, filter services.TodoFilter) {
+	filter.MatchAnyTag = r.URL.Query().Get("tag_mode") == "any"
+
+	if names := r.URL.Query()["tag"]; len(names) > 0 {
+		tags, err := h.TagService.GetTagsByNames(filter.UserID, names)
+		if err != nil {
+			log.Println(err)
+			response.Error(w, 500, "Failed to load todos")
+			return
+		}
+		// An unknown tag can never be matched when every tag is required
+		if len(tags) == 0 || (!filter.MatchAnyTag && len(tags) < len(uniqueNames(names))) {
+			response.Data(w, 200, struct {
+				Items []TodoWithDetails `json:"items"`
+			}{Items: []TodoWithDetails{}})
+			return
+		}
+		for _, tag := range tags {
+			tagID, _ := primitive.ObjectIDFromHex(tag.ID)
+			filter.TagIDs = append(filter.TagIDs, tagID)
+		}
+	}
+
+	todos, err := h.Service.GetAllTodos(filter)
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(500)
+		response.Error(w, 500, "Failed to load todos")
 		return
 	}
 
@@ -82,84 +299,57 @@ func (h *TodoHandler) getTodos(w http.ResponseWriter, r *http.Request) {
 		todos = []services.Todo{}
 	}
 
-	// Fetch details for each todo
-	var todosWithDetails []TodoWithDetails
-	for _, todo := range todos {
-		todoWithDetails := TodoWithDetails{
-			ID:          todo.ID,
-			UserID:      todo.UserID,
-			Task:        todo.Task,
-			DateStart:   todo.DateStart,
-			DateDue:     todo.DateDue,
-			Completed:   todo.Completed,
-			CreatedAt:   todo.CreatedAt,
-			UpdatedAt:   todo.UpdatedAt,
-			TodoDetails: nil, // Default to nil (will show as null in JSON)
-		}
+	todosWithDetails := h.withDetailsList(todos, h.tagsByID(filter.UserID))
 
-		// Try to get the details for this todo
-		details, err := h.DetailsService.GetTodoDetailsByTodoId(todo.ID)
-		if err == nil && details.ID != "" {
-			todoWithDetails.TodoDetails = &details
+	// Browsing the archive also gets the counts per month
+	var months []services.ArchiveMonth
+	if filter.Archived {
+		loc, err := archiveLocation(r)
+		if err != nil {
+			response.InvalidField(w, "timezone", "Invalid timezone")
+			return
+		}
+		months, err = h.Service.ArchiveHistory(filter.UserID, loc)
+		if err != nil {
+			log.Println(err)
+			response.Error(w, 500, "Failed to load archive history")
+			return
 		}
-
-		todosWithDetails = append(todosWithDetails, todoWithDetails)
 	}
 
-	// Response structure wrapper
-	response := struct {
-		Code int `json:"code"`
-		Data struct {
-			Items []TodoWithDetails `json:"items"`
-		} `json:"data"`
+	response.Data(w, 200, struct {
+		Items  []TodoWithDetails       `json:"items"`
+		Months []services.ArchiveMonth `json:"months,omitempty"`
 	}{
-		Code: 200,
-		Data: struct {
-			Items []TodoWithDetails `json:"items"`
-		}{
-			Items: todosWithDetails,
-		},
-	}
+		Items:  todosWithDetails,
+		Months: months,
+	})
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(response)
+// uniqueNames counts tag names the way tags are matched, ignoring case
+func uniqueNames(names []string) map[string]bool {
+	unique := map[string]bool{}
+	for _, name := range names {
+		unique[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return unique
 }
 
 // Logic to get todo by id
 // Updated with details todos included sorted by id
 func (h *TodoHandler) getTodoByID(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	todo, err := h.Service.GetTodoById(id)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(404)
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
 		return
 	}
 
 	// Create response with details
-	todoWithDetails := TodoWithDetails{
-		ID:          todo.ID,
-		UserID:      todo.UserID,
-		Task:        todo.Task,
-		DateStart:   todo.DateStart,
-		DateDue:     todo.DateDue,
-		Completed:   todo.Completed,
-		CreatedAt:   todo.CreatedAt,
-		UpdatedAt:   todo.UpdatedAt,
-		TodoDetails: nil, // Default to nil
-	}
-
-	// Try to get the details for this todo
-	details, err := h.DetailsService.GetTodoDetailsByTodoId(todo.ID)
-	if err == nil && details.ID != "" {
-		todoWithDetails.TodoDetails = &details
+	todoWithDetails := h.withDetails(todo, h.tagsByID(callerID(r)))
+	if notModified(w, r, entityTag(todo.Version, todoWithDetails)) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(todoWithDetails)
+	response.Data(w, 200, todoWithDetails)
 }
 
 // Create todo
@@ -193,23 +383,13 @@ func (h *TodoHandler) createTodo(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		log.Println(err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Invalid request body",
-			Code: 400,
-		})
+		response.Error(w, 400, "Invalid request body")
 		return
 	}
 
 	// Additional validation: check if task is not empty
 	if len(req.Task) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Task name is required",
-			Code: 400,
-		})
+		response.InvalidField(w, "task", "Task name is required")
 		return
 	}
 
@@ -221,12 +401,7 @@ func (h *TodoHandler) createTodo(w http.ResponseWriter, r *http.Request) {
 		ds, err := parseDateTime(req.DateStart)
 		if err != nil {
 			log.Println(err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(400)
-			json.NewEncoder(w).Encode(Response{
-				Msg:  "Invalid date_start format. Use YYYY-MM-DD or ISO 8601 format",
-				Code: 400,
-			})
+			response.InvalidField(w, "date_start", "Invalid date_start format. Use YYYY-MM-DD or ISO 8601 format")
 			return
 		}
 		dateStart = &ds
@@ -236,12 +411,7 @@ func (h *TodoHandler) createTodo(w http.ResponseWriter, r *http.Request) {
 		dd, err := parseDateTime(req.DateDue)
 		if err != nil {
 			log.Println(err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(400)
-			json.NewEncoder(w).Encode(Response{
-				Msg:  "Invalid date_due format. Use YYYY-MM-DD or ISO 8601 format",
-				Code: 400,
-			})
+			response.InvalidField(w, "date_due", "Invalid date_due format. Use YYYY-MM-DD or ISO 8601 format")
 			return
 		}
 		dateDue = &dd
@@ -250,41 +420,53 @@ func (h *TodoHandler) createTodo(w http.ResponseWriter, r *http.Request) {
 	// IMPORTANT: Validate date logic - start must be before or equal to due (only if both are provided)
 	if dateStart != nil && dateDue != nil && dateStart.After(*dateDue) {
 		log.Println("Start date is after due date")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Start date cannot be after due date",
-			Code: 400,
-		})
+		response.InvalidField(w, "date_start", "Start date cannot be after due date")
 		return
 	}
 
 	// Create the Todo
 	newTodo := services.Todo{
-		Task:      req.Task,
-		DateStart: dateStart,
-		DateDue:   dateDue,
-		Completed: req.Completed,
+		UserID:       callerID(r),
+		Task:         req.Task,
+		DateStart:    dateStart,
+		DateDue:      dateDue,
+		Completed:    req.Completed,
+		AutoComplete: req.AutoComplete,
+	}
+
+	if req.ProjectID != "" {
+		projectID, ok := h.activeProject(w, r, req.ProjectID)
+		if !ok {
+			return
+		}
+		newTodo.ProjectID = &projectID
 	}
 
-	err = h.Service.InsertTodo(newTodo)
+	if req.RRule != "" {
+		at := newTodo.OccurrenceTime()
+		if at == nil {
+			response.InvalidField(w, "rrule", "A repeating todo needs date_start or date_due")
+			return
+		}
+		rec, err := services.NewRecurrence(req.RRule, req.Timezone, *at)
+		if err != nil {
+			response.InvalidField(w, "rrule", err.Error())
+			return
+		}
+		newTodo.Recurrence = rec
+	}
+
+	todo, err := h.Service.InsertTodo(newTodo)
 	if err != nil {
 		log.Println(err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Failed to create todo",
-			Code: 500,
-		})
+		response.Error(w, 500, "Failed to create todo")
 		return
 	}
+	h.Service.RecordActivity(newTodo.UserID, "created", nil, todo)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-	json.NewEncoder(w).Encode(Response{
-		Msg:  "Successfully Created Todo",
-		Code: 201,
-	})
+	view := h.withDetails(todo, h.tagsByID(callerID(r)))
+	w.Header().Set("ETag", entityTag(todo.Version, view))
+	response.Created(w, apiBase+"/todos/"+todo.ID, view)
 }
 
 // Also update the updateTodo function with the same validation
@@ -295,23 +477,13 @@ func (h *TodoHandler) updateTodo(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		log.Println(err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Invalid request body",
-			Code: 400,
-		})
+		response.Error(w, 400, "Invalid request body")
 		return
 	}
 
 	// Validate task
 	if len(req.Task) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Task name is required",
-			Code: 400,
-		})
+		response.InvalidField(w, "task", "Task name is required")
 		return
 	}
 
@@ -323,12 +495,7 @@ func (h *TodoHandler) updateTodo(w http.ResponseWriter, r *http.Request) {
 		ds, err := parseDateTime(req.DateStart)
 		if err != nil {
 			log.Println(err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(400)
-			json.NewEncoder(w).Encode(Response{
-				Msg:  "Invalid date_start format",
-				Code: 400,
-			})
+			response.InvalidField(w, "date_start", "Invalid date_start format")
 			return
 		}
 		dateStart = &ds
@@ -338,12 +505,7 @@ func (h *TodoHandler) updateTodo(w http.ResponseWriter, r *http.Request) {
 		dd, err := parseDateTime(req.DateDue)
 		if err != nil {
 			log.Println(err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(400)
-			json.NewEncoder(w).Encode(Response{
-				Msg:  "Invalid date_due format",
-				Code: 400,
-			})
+			response.InvalidField(w, "date_due", "Invalid date_due format")
 			return
 		}
 		dateDue = &dd
@@ -352,107 +514,290 @@ func (h *TodoHandler) updateTodo(w http.ResponseWriter, r *http.Request) {
 	// Validate date logic (only if both are provided)
 	if dateStart != nil && dateDue != nil && dateStart.After(*dateDue) {
 		log.Println("Start date is after due date")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Start date cannot be after due date",
-			Code: 400,
-		})
+		response.InvalidField(w, "date_start", "Start date cannot be after due date")
 		return
 	}
 
+	// scope=future also applies the edit to the later occurrences of a
+	// repeating todo
+	scope := r.URL.Query().Get("scope")
+	if scope != "" && scope != services.ScopeThis && scope != services.ScopeFuture {
+		response.InvalidField(w, "scope", "scope must be this or future")
+		return
+	}
+	before, ok := loadOwnedTodo(w, r, h.Service, id)
+	if !ok {
+		return
+	}
+	if scope == services.ScopeFuture && before.Recurrence == nil {
+		response.Error(w, 400, "Todo does not repeat")
+		return
+	}
+	expected, ok := checkIfMatch(w, r, before.Version, h.todoETag(r, before))
+	if !ok {
+		return
+	}
+	if req.Completed && !before.Completed && !checkBlockers(w, r, h.Service, before) {
+		return
+	}
+	state := loadTodoState(before)
+
 	updateTodo := services.Todo{
-		Task:      req.Task,
-		DateStart: dateStart,
-		DateDue:   dateDue,
-		Completed: req.Completed,
+		Task:         req.Task,
+		DateStart:    dateStart,
+		DateDue:      dateDue,
+		Completed:    req.Completed,
+		AutoComplete: req.AutoComplete,
 	}
 
-	_, err = h.Service.UpdatedTodo(id, updateTodo)
+	_, err = h.Service.UpdatedTodo(id, updateTodo, expected)
+	if writeVersionConflict(w, err) {
+		return
+	}
 	if err != nil {
 		log.Println(err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  err.Error(),
-			Code: 500,
-		})
+		response.Error(w, 500, "Failed to update todo")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(Response{
-		Msg:  "Successfully Updated Todo",
-		Code: 200,
-	})
+	if updateTodo.Completed != before.Completed {
+		if todo, err := h.Service.GetTodoById(id); err == nil {
+			followCompletion(h.Service, h.DetailsService, callerID(r), todo)
+		}
+	}
+	if err := h.ReminderService.RescheduleReminders(id, updateTodo.DateDue); err != nil {
+		log.Println(err)
+	}
+	recordActivity(h.Service, callerID(r), "updated", state)
+
+	if scope == services.ScopeFuture {
+		if _, err := h.Service.UpdateFutureOccurrences(before, updateTodo); err != nil {
+			log.Println(err)
+			response.Error(w, 500, "Failed to update future occurrences")
+			return
+		}
+	}
+
+	response.Message(w, 200, "Successfully Updated Todo")
 }
 
+// Toggle the completion state in one atomic update, the response is the
+// todo afterwards
 func (h *TodoHandler) toggleComplete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	// Get the current todo
-	todo, err := h.Service.GetTodoById(id)
+	todo, ok := loadOwnedTodo(w, r, h.Service, id)
+	if !ok {
+		return
+	}
+	expected, ok := checkIfMatch(w, r, todo.Version, h.todoETag(r, todo))
+	if !ok {
+		return
+	}
+
+	// Open blockers keep a todo from being completed unless ?force=true,
+	// the toggle then only reopens it
+	open := []services.Todo{}
+	if r.URL.Query().Get("force") != "true" {
+		var err error
+		open, err = h.Service.OpenBlockers(todo)
+		if err != nil {
+			log.Println(err)
+			response.Error(w, 500, "Failed to check blockers")
+			return
+		}
+	}
+
+	before, err := h.Service.ToggleTodoCompleted(id, len(open) > 0, expected)
+	if errors.Is(err, services.ErrTodoBlocked) {
+		writeBlocked(w, open)
+		return
+	}
 	if err != nil {
-		log.Println(err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(404)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Todo not found",
-			Code: 404,
-		})
+		writeCompletionError(w, err, "Failed to toggle completion status")
 		return
 	}
+	h.completionChanged(w, r, before)
+}
+
+// Complete the todo, completing a completed todo changes nothing
+func (h *TodoHandler) completeTodo(w http.ResponseWriter, r *http.Request) {
+	h.setCompletion(w, r, true)
+}
 
-	// Toggle the completed status
-	todo.Completed = !todo.Completed
+// Reopen the todo, reopening an open todo changes nothing
+func (h *TodoHandler) uncompleteTodo(w http.ResponseWriter, r *http.Request) {
+	h.setCompletion(w, r, false)
+}
 
-	_, err = h.Service.UpdatedTodo(id, todo)
+func (h *TodoHandler) setCompletion(w http.ResponseWriter, r *http.Request, completed bool) {
+	todo, ok := loadOwnedTodo(w, r, h.Service, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	expected, ok := checkIfMatch(w, r, todo.Version, h.todoETag(r, todo))
+	if !ok {
+		return
+	}
+
+	if completed && !todo.Completed && !checkBlockers(w, r, h.Service, todo) {
+		return
+	}
+
+	before, changed, err := h.Service.CompleteTodo(todo.ID, completed, expected)
 	if err != nil {
+		writeCompletionError(w, err, "Failed to change completion status")
+		return
+	}
+	if !changed {
+		h.writeTodo(w, r, before)
+		return
+	}
+	h.completionChanged(w, r, before)
+}
+
+func writeCompletionError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrTodoNotFound):
+		response.Error(w, 404, "Todo not found")
+	case writeVersionConflict(w, err):
+	default:
 		log.Println(err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		json.NewEncoder(w).Encode(Response{
-			Msg:  "Failed to toggle completion status",
-			Code: 500,
-		})
+		response.Error(w, 500, fallback)
+	}
+}
+
+// completionChanged follows up on a todo that got completed or reopened,
+// before is the todo as it was, and responds with the todo afterwards
+func (h *TodoHandler) completionChanged(w http.ResponseWriter, r *http.Request, before services.Todo) {
+	state := loadTodoState(before)
+	todo, err := h.Service.GetTodoById(before.ID)
+	if err != nil {
+		response.Error(w, 500, "Failed to load todo")
 		return
 	}
+	recordActivity(h.Service, callerID(r), completionAction(todo.Completed), state)
+	followCompletion(h.Service, h.DetailsService, callerID(r), todo)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(Response{
-		Msg:  "Successfully toggled completion status",
-		Code: 200,
-	})
+	h.writeTodo(w, r, todo)
 }
 
-// Delete Todo it can be used only by id (On going to make delete all todos)
-func (h *TodoHandler) deleteTodo(w http.ResponseWriter, r *http.Request) {
+// followCompletion does what follows every completion or reopening of a
+// todo, whichever way it came about: the details status goes along and
+// completing an occurrence of a repeating todo schedules the next one.
+// todo is the todo afterwards.
+func followCompletion(todos services.Todo, details services.TodoDetails, userID primitive.ObjectID, todo services.Todo) {
+	if err := details.SyncStatusWithCompletion(todo.ID, todo.Completed); err != nil {
+		log.Println(err)
+	}
+	if !todo.Completed {
+		return
+	}
+	next, created, err := todos.CreateNextOccurrence(todo)
+	if err != nil {
+		log.Println("Could not create next occurrence: ", err)
+	} else if created {
+		todos.RecordActivity(userID, "created", nil, next)
+	}
+}
+
+// activeProject resolves one of the caller's projects that todos can be
+// added to, writing the error response itself when there is none
+func (h *TodoHandler) activeProject(w http.ResponseWriter, r *http.Request, id string) (primitive.ObjectID, bool) {
+	project, err := h.ProjectService.GetProjectById(callerID(r), id)
+	if err != nil {
+		writeProjectError(w, err, "Failed to load project")
+		return primitive.NilObjectID, false
+	}
+	if project.Archived {
+		response.Error(w, 409, "Project is archived")
+		return primitive.NilObjectID, false
+	}
+
+	projectID, _ := primitive.ObjectIDFromHex(project.ID)
+	return projectID, true
+}
+
+// Move a todo to another project or back to the inbox
+func (h *TodoHandler) setTodoProject(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	var req SetTodoProjectRequest
 
-	err := h.Service.DeleteTodo(id)
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		errorRes := Response{
-			Msg:  "Error",
-			Code: 304,
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	var projectID *primitive.ObjectID
+	if req.ProjectID != nil {
+		mongoID, ok := h.activeProject(w, r, *req.ProjectID)
+		if !ok {
+			return
 		}
-		json.NewEncoder(w).Encode(errorRes)
-		w.WriteHeader(errorRes.Code)
+		projectID = &mongoID
+	}
+
+	before, ok := loadOwnedTodo(w, r, h.Service, id)
+	if !ok {
 		return
 	}
+	state := loadTodoState(before)
 
-	res := Response{
-		Msg:  "Succesfully Deleted Todo",
-		Code: 200,
+	err = h.Service.SetTodoProject(callerID(r), id, projectID)
+	if errors.Is(err, services.ErrTodoNotFound) {
+		response.Error(w, 404, "Todo not found")
+		return
 	}
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to move todo")
+		return
+	}
+	recordActivity(h.Service, callerID(r), "project_changed", state)
+
+	response.Message(w, 200, "Successfully Moved Todo")
+}
 
-	jsonStr, err := json.Marshal(res)
+// Delete Todo moves it to the trash together with its details
+func (h *TodoHandler) deleteTodo(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	before, ok := loadOwnedTodo(w, r, h.Service, id)
+	if !ok {
+		return
+	}
+	expected, ok := checkIfMatch(w, r, before.Version, h.todoETag(r, before))
+	if !ok {
+		return
+	}
+	state := loadTodoState(before)
+
+	result, err := h.Service.TrashTodo(callerID(r), id, expected)
+	if writeVersionConflict(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrTodoNotFound) {
+		response.Error(w, 404, "Todo not found")
+		return
+	}
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		response.Error(w, 500, "Failed to delete todo")
+		return
+	}
+
+	// The todo is out of reach now, its trashed state is what was loaded
+	// with the time it went to the trash
+	trashed := state
+	now := time.Now()
+	trashed.todo.DeletedAt = &now
+	if state.details != nil {
+		details := *state.details
+		details.DeletedAt = &now
+		trashed.details = &details
 	}
+	recordStates(h.Service, callerID(r), "trashed", state, trashed)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(res.Code)
-	w.Write(jsonStr)
+	response.Data(w, 200, result)
 }
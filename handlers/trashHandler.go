@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/response"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func writeTrashError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrTrashItemNotFound), errors.Is(err, services.ErrTodoNotFound):
+		response.Error(w, 404, "Item not found in trash")
+	case errors.Is(err, services.ErrParentTrashed):
+		response.Error(w, 409, "Restore the todo these details belong to first")
+	case errors.Is(err, services.ErrTodoDetailsExists):
+		response.Error(w, 409, "Todo already has details")
+	default:
+		log.Println(err)
+		response.Error(w, 500, fallback)
+	}
+}
+
+// List the caller's trashed todos and details
+func (h *TodoHandler) getTrash(w http.ResponseWriter, r *http.Request) {
+	items, err := h.Service.GetTrash(callerID(r))
+	if err != nil {
+		writeTrashError(w, err, "Failed to load trash")
+		return
+	}
+
+	response.Data(w, 200, struct {
+		Items []services.TrashItem `json:"items"`
+	}{
+		Items: items,
+	})
+}
+
+func (h *TodoHandler) restoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	item, err := h.Service.RestoreFromTrash(callerID(r), chi.URLParam(r, "id"))
+	if err != nil {
+		writeTrashError(w, err, "Failed to restore item")
+		return
+	}
+	h.recordRestore(callerID(r), item)
+
+	response.Data(w, 200, item)
+}
+
+// recordRestore records a restore as the trashed state going away
+func (h *TodoHandler) recordRestore(userID primitive.ObjectID, item services.TrashItem) {
+	todo, err := h.Service.GetTodoById(item.TodoID)
+	if err != nil {
+		return
+	}
+	after := loadTodoState(todo)
+	before := todoState{todo: todo}
+	if item.Kind == services.TrashKindTodo {
+		before.todo.DeletedAt = &item.DeletedAt
+		if after.details != nil {
+			details := *after.details
+			details.DeletedAt = &item.DeletedAt
+			before.details = &details
+		}
+	}
+	recordStates(h.Service, userID, "restored", before, after)
+}
+
+// Permanently delete one item without waiting for the purge
+func (h *TodoHandler) purgeTrashItem(w http.ResponseWriter, r *http.Request) {
+	err := h.Service.PurgeTrashItem(callerID(r), chi.URLParam(r, "id"))
+	if err != nil {
+		writeTrashError(w, err, "Failed to delete item")
+		return
+	}
+
+	response.Message(w, 200, "Permanently Deleted Item")
+}
@@ -2,37 +2,61 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/yogisyo16/root-aura-service/notifier"
+	"github.com/yogisyo16/root-aura-service/response"
 	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserHandler struct {
-	Service services.User
+	Service       services.User
+	DigestService services.Digest
 }
 
-func NewUserHandler(service services.User) *UserHandler {
+func NewUserHandler(service services.User, digestService services.Digest) *UserHandler {
 	return &UserHandler{
-		Service: service,
+		Service:       service,
+		DigestService: digestService,
 	}
 }
 
+// Update preferences request structure
+type UserPreferencesRequest struct {
+	Timezone        string `json:"timezone"`
+	DigestFrequency string `json:"digest_frequency"`
+}
+
+// validPreferences answers 400 for a timezone or digest frequency that
+// cannot be stored
+func validPreferences(w http.ResponseWriter, timezone string, frequency string) bool {
+	err := services.ValidateUserPreferences(timezone, frequency)
+	var invalid *services.PreferenceError
+	if errors.As(err, &invalid) {
+		response.InvalidField(w, invalid.Field, invalid.Message)
+		return false
+	}
+	return err == nil
+}
+
 func (h *UserHandler) insertUser(w http.ResponseWriter, r *http.Request) {
 	var newUser services.User
 
 	err := json.NewDecoder(r.Body).Decode(&newUser)
 	if err != nil {
 		log.Println(err)
-		errorRes := Response{
-			Msg:  "Invalid request body",
-			Code: 400,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(errorRes.Code)
-		json.NewEncoder(w).Encode(errorRes)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+
+	if !validPreferences(w, newUser.Timezone, newUser.DigestFrequency) {
 		return
 	}
 
@@ -40,95 +64,141 @@ func (h *UserHandler) insertUser(w http.ResponseWriter, r *http.Request) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
 	if err != nil {
 		log.Println(err)
-		errorRes := Response{
-			Msg:  "Error processing password",
-			Code: 500,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(errorRes.Code)
-		json.NewEncoder(w).Encode(errorRes)
+		response.Error(w, 500, "Error processing password")
 		return
 	}
 	newUser.Password = string(hashedPassword)
 
-	err = h.Service.InsertUser(newUser)
+	user, err := h.Service.InsertUser(newUser)
 	if err != nil {
-		errorRes := Response{
-			Msg:  "Error creating user",
-			Code: 500,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(errorRes.Code)
-		json.NewEncoder(w).Encode(errorRes)
+		log.Println(err)
+		response.Error(w, 500, "Error creating user")
 		return
 	}
 
-	res := Response{
-		Msg:  "Successfully Created User",
-		Code: 201,
-	}
+	// The password hash never leaves the server
+	user.Password = ""
+	response.Created(w, apiBase+"/users/"+user.ID, user)
+}
 
-	jsonStr, err := json.Marshal(res)
+func (h *UserHandler) getAllUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.Service.GetAllUsers()
 	if err != nil {
 		log.Println(err)
+		response.Error(w, 500, "Failed to load users")
 		return
 	}
+	if users == nil {
+		users = []services.User{}
+	}
+	for i := range users {
+		users[i].Password = ""
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(res.Code)
-	w.Write(jsonStr)
+	response.Data(w, 200, struct {
+		Items []services.User `json:"items"`
+	}{
+		Items: users,
+	})
 }
 
-func (h *UserHandler) getAllUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.Service.GetAllUsers()
+func (h *UserHandler) getUserByID(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	user, err := h.Service.GetUserByID(id)
 	if err != nil {
-		log.Println(err)
+		if errors.Is(err, mongo.ErrNoDocuments) || !primitive.IsValidObjectID(id) {
+			response.Error(w, 404, "User not found")
+			return
+		}
+		response.Error(w, 500, "Failed to load user")
 		return
 	}
+	user.Password = ""
 
-	response := struct {
-		Code int `json:"code"`
-		Data struct {
-			Items []services.User `json:"items"`
-		} `json:"data"`
+	response.Data(w, 200, struct {
+		Items services.User `json:"items"`
 	}{
-		Code: 200,
-		Data: struct {
-			Items []services.User `json:"items"`
-		}{
-			Items: users,
-		},
+		Items: user,
+	})
+}
+
+// Update the caller's timezone and digest frequency, "off" opts out of
+// digests
+func (h *UserHandler) updatePreferences(w http.ResponseWriter, r *http.Request) {
+	var req UserPreferencesRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 400, "Invalid request body")
+		return
+	}
+	if !validPreferences(w, req.Timezone, req.DigestFrequency) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(response)
+	err = h.Service.UpdateUserPreferences(callerID(r).Hex(), req.Timezone, req.DigestFrequency)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			response.Error(w, 404, "User not found")
+			return
+		}
+		log.Println(err)
+		response.Error(w, 500, "Failed to update preferences")
+		return
+	}
+
+	response.Message(w, 200, "Successfully Updated Preferences")
 }
 
-func (h *UserHandler) getUserByID(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+// Preview the caller's digest as it would be sent now,
+// ?frequency=daily|weekly and ?format=json|text|html
+func (h *UserHandler) previewDigest(w http.ResponseWriter, r *http.Request) {
+	user, err := h.Service.GetUserByID(callerID(r).Hex())
+	if err != nil {
+		response.Error(w, 404, "User not found")
+		return
+	}
 
-	user, err := h.Service.GetUserByID(id)
+	frequency := r.URL.Query().Get("frequency")
+	if frequency == "" {
+		frequency = services.DigestDaily
+	}
+	if frequency != services.DigestDaily && frequency != services.DigestWeekly {
+		response.InvalidField(w, "frequency", "frequency must be daily or weekly")
+		return
+	}
+
+	digest, err := h.DigestService.BuildDigest(r.Context(), user, frequency, time.Now())
 	if err != nil {
 		log.Println(err)
+		response.Error(w, 500, "Failed to build digest")
 		return
 	}
 
-	response := struct {
-		Code int `json:"code"`
-		Data struct {
-			Items services.User `json:"items"`
-		} `json:"data"`
-	}{
-		Code: 200,
-		Data: struct {
-			Items services.User `json:"items"`
-		}{
-			Items: user,
-		},
+	format := r.URL.Query().Get("format")
+	if format == "" || format == "json" {
+		response.Data(w, 200, digest)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(response)
+	email, err := notifier.RenderDigest(digest)
+	if err != nil {
+		log.Println(err)
+		response.Error(w, 500, "Failed to render digest")
+		return
+	}
+	switch format {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(200)
+		w.Write([]byte(email.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(200)
+		w.Write([]byte(email.Text))
+	default:
+		response.InvalidField(w, "format", "format must be json, text or html")
+	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/yogisyo16/root-aura-service/db"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const usage = `usage: maintenance <command> [flags]
+
+commands:
+  migrate          rewrite stored documents into the current schema
+  repair-orphans   report todo details whose todo no longer exists
+  assign-owner     give todos created before todos had owners to a user
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	mongoClient, err := db.ConnectToMongo()
+	if err != nil {
+		log.Fatal("Could not connect to the database")
+	}
+	services.New(mongoClient)
+
+	switch os.Args[1] {
+	case "migrate":
+		migrate(os.Args[2:])
+	case "repair-orphans":
+		repairOrphans(os.Args[2:])
+	case "assign-owner":
+		assignOwner(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	batchSize := flags.Int("batch", 500, "documents rewritten per bulk write")
+	dryRun := flags.Bool("dry-run", false, "report what would change without writing")
+	flags.Parse(args)
+
+	results, err := services.RunMigrations(context.Background(), *batchSize, *dryRun)
+	for _, res := range results {
+		fmt.Printf("%-45s scanned=%d modified=%d failed=%d\n", res.Migration, res.Scanned, res.Modified, res.Failed)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Todos from before owners existed are invisible to every user until
+	// they are handed to one
+	todo := services.Todo{}
+	ownerless, err := todo.CountOwnerlessTodos(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ownerless > 0 {
+		log.Fatalf("%d todos have no owner, run `maintenance assign-owner -user <id>`", ownerless)
+	}
+}
+
+func repairOrphans(args []string) {
+	flags := flag.NewFlagSet("repair-orphans", flag.ExitOnError)
+	del := flags.Bool("delete", false, "delete the orphaned details instead of only reporting them")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	details := services.TodoDetails{}
+
+	orphans, err := details.FindOrphanedTodoDetails(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, orphan := range orphans {
+		fmt.Printf("orphan todo_details %s (todo_id %s)\n", orphan.ID, orphan.TodoID.Hex())
+	}
+	fmt.Printf("found %d orphaned todo details\n", len(orphans))
+
+	if !*del || len(orphans) == 0 {
+		return
+	}
+	deleted, err := details.DeleteOrphanedTodoDetails(ctx, orphans)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("deleted %d orphaned todo details\n", deleted)
+}
+
+func assignOwner(args []string) {
+	flags := flag.NewFlagSet("assign-owner", flag.ExitOnError)
+	user := flags.String("user", "", "id of the user receiving the todos")
+	flags.Parse(args)
+
+	userID, err := primitive.ObjectIDFromHex(*user)
+	if err != nil {
+		log.Fatal("-user must be a user id")
+	}
+
+	todo := services.Todo{}
+	assigned, err := todo.AssignOwner(userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("assigned %d todos to %s\n", assigned, userID.Hex())
+}
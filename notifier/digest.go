@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+//go:embed templates
+var templates embed.FS
+
+var digestFuncs = map[string]any{
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format("Mon 2 Jan 15:04")
+	},
+}
+
+var (
+	digestText = texttemplate.Must(texttemplate.New("digest.txt").Funcs(digestFuncs).ParseFS(templates, "templates/digest.txt"))
+	digestHTML = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(digestFuncs).ParseFS(templates, "templates/digest.html"))
+)
+
+// digestView is what the digest templates render, dates are in the user's
+// timezone
+type digestView struct {
+	Name           string
+	Frequency      string
+	Period         string
+	DueLabel       string
+	CompletedLabel string
+	Due            []services.DigestEntry
+	Overdue        []services.DigestEntry
+	Completed      []services.DigestEntry
+}
+
+func localEntries(entries []services.DigestEntry, loc *time.Location) []services.DigestEntry {
+	local := make([]services.DigestEntry, len(entries))
+	for i, entry := range entries {
+		if entry.DateDue != nil {
+			due := entry.DateDue.In(loc)
+			entry.DateDue = &due
+		}
+		local[i] = entry
+	}
+	return local
+}
+
+// RenderDigest renders the digest into an email to its user
+func RenderDigest(digest services.Digest) (Email, error) {
+	loc := digest.User.Location()
+	view := digestView{
+		Name:           strings.TrimSpace(digest.User.FirstName),
+		Frequency:      digest.Frequency,
+		Period:         digest.PeriodStart.Format("Monday 2 January 2006"),
+		DueLabel:       "today",
+		CompletedLabel: "yesterday",
+		Due:            localEntries(digest.Due, loc),
+		Overdue:        localEntries(digest.Overdue, loc),
+		Completed:      localEntries(digest.Completed, loc),
+	}
+	if view.Name == "" {
+		view.Name = "there"
+	}
+	if digest.Frequency == services.DigestWeekly {
+		view.Period = "the week of " + view.Period
+		view.DueLabel = "this week"
+		view.CompletedLabel = "last week"
+	}
+
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, view); err != nil {
+		return Email{}, err
+	}
+	if err := digestHTML.Execute(&html, view); err != nil {
+		return Email{}, err
+	}
+
+	subject := "Your todos for today"
+	if digest.Frequency == services.DigestWeekly {
+		subject = "Your todos for this week"
+	}
+	return Email{
+		To:      digest.User.Email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
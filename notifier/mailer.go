@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Email is a message with a plain text and an HTML body
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// LogMailer writes the subject and recipient of emails to the application log
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, email Email) error {
+	log.Printf("Mail to %s: %s\n", email.To, email.Subject)
+	return nil
+}
+
+// DirMailer writes every email as a .eml file into Dir, handy to look at
+// rendered digests locally
+type DirMailer struct {
+	Dir string
+}
+
+func (m DirMailer) Send(ctx context.Context, email Email) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), primitive.NewObjectID().Hex())
+	return os.WriteFile(filepath.Join(m.Dir, name), message("", email), 0o644)
+}
+
+// SMTPMailer sends emails through an SMTP server with PLAIN auth
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(ctx context.Context, email Email) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := strings.Cut(m.Addr, ":")
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{email.To}, message(m.From, email))
+}
+
+// message builds a multipart/alternative MIME message
+func message(from string, email Email) []byte {
+	boundary := primitive.NewObjectID().Hex()
+
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", email.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, email.Text)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", boundary, email.HTML)
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return []byte(b.String())
+}
+
+// MailerFromEnv picks the mailer configured by MAILER: "log" (default),
+// "dir" writing to MAILER_DIR or "smtp" using SMTP_ADDR, SMTP_USERNAME,
+// SMTP_PASSWORD and MAIL_FROM
+func MailerFromEnv() Mailer {
+	switch os.Getenv("MAILER") {
+	case "dir":
+		dir := os.Getenv("MAILER_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return DirMailer{Dir: dir}
+	case "smtp":
+		return SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	}
+	return LogMailer{}
+}
@@ -0,0 +1,73 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notification is a message for one user about one of their todos
+type Notification struct {
+	UserID  string    `json:"user_id"`
+	TodoID  string    `json:"todo_id"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Notifier delivers notifications. Returning an error makes the caller
+// retry later.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// LogNotifier writes notifications to the application log
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, n Notification) error {
+	log.Printf("Notify user %s about todo %s: %s\n", n.UserID, n.TodoID, n.Subject)
+	return nil
+}
+
+// FileNotifier appends notifications as JSON lines to a file, which makes
+// deliveries easy to inspect locally and in tests
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{Path: path}
+}
+
+func (f *FileNotifier) Notify(ctx context.Context, n Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if n.SentAt.IsZero() {
+		n.SentAt = time.Now()
+	}
+	return json.NewEncoder(file).Encode(n)
+}
+
+// FromEnv picks the notifier configured by NOTIFIER ("log" or "file"),
+// NOTIFIER_FILE names the file and defaults to notifications.jsonl
+func FromEnv() Notifier {
+	if os.Getenv("NOTIFIER") == "file" {
+		path := os.Getenv("NOTIFIER_FILE")
+		if path == "" {
+			path = "notifications.jsonl"
+		}
+		return NewFileNotifier(path)
+	}
+	return LogNotifier{}
+}
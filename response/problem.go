@@ -0,0 +1,82 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Problem types clients can tell errors apart by. Every other error is
+// about:blank, its title is the status text.
+const (
+	TypeValidation     = "/problems/validation-error"
+	TypeTodoBlocked    = "/problems/todo-blocked"
+	TypeIdempotencyKey = "/problems/idempotency-key-reused"
+)
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+	// Extensions are written as members of their own next to the others
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// FieldError tells what is wrong with one field of a request body
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	encoded, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return encoded, err
+	}
+
+	members := map[string]interface{}{}
+	for name, value := range p.Extensions {
+		members[name] = value
+	}
+	if err := json.Unmarshal(encoded, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// WriteProblem answers with p, filling in what it leaves out
+func WriteProblem(w http.ResponseWriter, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	write(w, "application/problem+json", p.Status, p)
+}
+
+// Error answers with a problem of the status, detail explains this
+// occurrence of it
+func Error(w http.ResponseWriter, status int, detail string) {
+	WriteProblem(w, Problem{Status: status, Detail: detail})
+}
+
+// Invalid answers 400 for a request body that did not validate, errs
+// point at the fields at fault
+func Invalid(w http.ResponseWriter, detail string, errs ...FieldError) {
+	WriteProblem(w, Problem{
+		Type:   TypeValidation,
+		Title:  "Request is invalid",
+		Status: 400,
+		Detail: detail,
+		Errors: errs,
+	})
+}
+
+// InvalidField answers 400 for a request with one field at fault
+func InvalidField(w http.ResponseWriter, field string, detail string) {
+	Invalid(w, detail, FieldError{Field: field, Detail: detail})
+}
@@ -0,0 +1,83 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProblemMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		problem Problem
+		want    map[string]interface{}
+	}{
+		{
+			name:    "plain",
+			problem: Problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "Todo not found"},
+			want:    map[string]interface{}{"type": "about:blank", "title": "Not Found", "status": 404.0, "detail": "Todo not found"},
+		},
+		{
+			name:    "empty detail and errors left out",
+			problem: Problem{Type: "about:blank", Title: "Conflict", Status: 409},
+			want:    map[string]interface{}{"type": "about:blank", "title": "Conflict", "status": 409.0},
+		},
+		{
+			name: "field errors",
+			problem: Problem{Type: TypeValidation, Title: "Bad Request", Status: 400, Detail: "Invalid date",
+				Errors: []FieldError{{Field: "date_due", Detail: "Invalid date"}}},
+			want: map[string]interface{}{"type": TypeValidation, "title": "Bad Request", "status": 400.0, "detail": "Invalid date",
+				"errors": []interface{}{map[string]interface{}{"field": "date_due", "detail": "Invalid date"}}},
+		},
+		{
+			name: "extensions are members of their own",
+			problem: Problem{Type: TypeTodoBlocked, Title: "Conflict", Status: 409,
+				Extensions: map[string]interface{}{"blockers": []string{"a"}}},
+			want: map[string]interface{}{"type": TypeTodoBlocked, "title": "Conflict", "status": 409.0,
+				"blockers": []interface{}{"a"}},
+		},
+		{
+			name: "extensions cannot override members",
+			problem: Problem{Type: "about:blank", Title: "Conflict", Status: 409,
+				Extensions: map[string]interface{}{"status": 200, "title": "OK"}},
+			want: map[string]interface{}{"type": "about:blank", "title": "Conflict", "status": 409.0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := json.Marshal(tt.problem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(encoded, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("json = %s, want %v", encoded, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidField(t *testing.T) {
+	w := httptest.NewRecorder()
+	InvalidField(w, "title", "Title is required")
+
+	if w.Code != 400 {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var got Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := Problem{Type: TypeValidation, Title: "Request is invalid", Status: 400, Detail: "Title is required",
+		Errors: []FieldError{{Field: "title", Detail: "Title is required"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problem = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,44 @@
+// Package response writes the bodies every handler answers with. Successes
+// come in the {"code", "data"} envelope, or {"code", "message"} when there
+// is nothing to return, and errors as RFC 7807 problem details.
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Data answers with data in the {"code", "data"} envelope
+func Data(w http.ResponseWriter, code int, data interface{}) {
+	write(w, "application/json", code, struct {
+		Code int         `json:"code"`
+		Data interface{} `json:"data"`
+	}{
+		Code: code,
+		Data: data,
+	})
+}
+
+// Created answers 201 with the created resource in the data envelope,
+// location is where the resource can be read back
+func Created(w http.ResponseWriter, location string, data interface{}) {
+	w.Header().Set("Location", location)
+	Data(w, 201, data)
+}
+
+// Message answers a success that has no resource to return
+func Message(w http.ResponseWriter, code int, msg string) {
+	write(w, "application/json", code, struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{
+		Code:    code,
+		Message: msg,
+	})
+}
+
+func write(w http.ResponseWriter, contentType string, code int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// ArchivePolicy archives todos that were completed more than After ago
+type ArchivePolicy struct {
+	Todos    services.Todo
+	After    time.Duration
+	Interval time.Duration
+}
+
+func NewArchivePolicy(todos services.Todo) *ArchivePolicy {
+	return &ArchivePolicy{
+		Todos:    todos,
+		After:    30 * 24 * time.Hour,
+		Interval: time.Hour,
+	}
+}
+
+// Run archives every Interval until ctx is cancelled
+func (p *ArchivePolicy) Run(ctx context.Context) {
+	log.Printf("Archive policy started, todos are archived %s after completion\n", p.After)
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := p.Todos.ArchiveCompletedBefore(ctx, time.Now().Add(-p.After))
+		if err != nil && ctx.Err() == nil {
+			log.Println("Archive policy: ", err)
+		} else if count > 0 {
+			log.Printf("Archived %d completed todos\n", count)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/yogisyo16/root-aura-service/notifier"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DigestScheduler sends every user their digest once their local time
+// passes Hour, weekly digests go out on Weekday. A digest_runs record per
+// user and period keeps instances from sending one twice.
+type DigestScheduler struct {
+	Users   services.User
+	Digests services.Digest
+	Mailer  notifier.Mailer
+	// Interval is how often users are checked for a due digest
+	Interval time.Duration
+	Hour     int
+	Weekday  time.Weekday
+	// SendEmpty also sends digests without any todos in them
+	SendEmpty bool
+}
+
+func NewDigestScheduler(users services.User, digests services.Digest, mailer notifier.Mailer) *DigestScheduler {
+	return &DigestScheduler{
+		Users:    users,
+		Digests:  digests,
+		Mailer:   mailer,
+		Interval: 5 * time.Minute,
+		Hour:     7,
+		Weekday:  time.Monday,
+	}
+}
+
+// Run sends due digests every Interval until ctx is cancelled
+func (s *DigestScheduler) Run(ctx context.Context) {
+	log.Printf("Digest scheduler started, digests go out at %02d:00 local time\n", s.Hour)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RunOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Println("Digest scheduler: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// due returns the frequency and period of the digest the user should get
+// at now, false when none is due
+func (s *DigestScheduler) due(user services.User, now time.Time) (string, string, bool) {
+	local := now.In(user.Location())
+	if local.Hour() < s.Hour {
+		return "", "", false
+	}
+
+	frequency := user.DigestFrequency
+	if frequency == "" {
+		frequency = services.DigestDaily
+	}
+	if frequency == services.DigestWeekly && local.Weekday() != s.Weekday {
+		return "", "", false
+	}
+	return frequency, local.Format("2006-01-02"), true
+}
+
+// RunOnce sends the digests due at now and returns how many were sent
+func (s *DigestScheduler) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	users, err := s.Users.GetDigestRecipients(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, user := range users {
+		frequency, period, ok := s.due(user, now)
+		if !ok {
+			continue
+		}
+		userID, err := primitive.ObjectIDFromHex(user.ID)
+		if err != nil {
+			continue
+		}
+
+		claimed, err := s.Digests.ClaimDigestRun(ctx, userID, frequency, period)
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := s.send(ctx, user, frequency, now); err != nil {
+			log.Printf("Could not send digest to user %s: %v\n", user.ID, err)
+			if err := s.Digests.ReleaseDigestRun(ctx, userID, frequency, period); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func (s *DigestScheduler) send(ctx context.Context, user services.User, frequency string, now time.Time) error {
+	digest, err := s.Digests.BuildDigest(ctx, user, frequency, now)
+	if err != nil {
+		return err
+	}
+	if digest.Empty() && !s.SendEmpty {
+		return nil
+	}
+
+	email, err := notifier.RenderDigest(digest)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(ctx, email)
+}
@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// TrashPurger permanently removes what has been in the trash for longer
+// than Retention. Running it on several instances is safe, a todo purged by
+// one is skipped by the others.
+type TrashPurger struct {
+	Todos     services.Todo
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+func NewTrashPurger(todos services.Todo) *TrashPurger {
+	return &TrashPurger{
+		Todos:     todos,
+		Retention: 30 * 24 * time.Hour,
+		Interval:  time.Hour,
+	}
+}
+
+// Run purges every Interval until ctx is cancelled
+func (p *TrashPurger) Run(ctx context.Context) {
+	log.Printf("Trash purger started, items are kept for %s\n", p.Retention)
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := p.Todos.PurgeTrash(ctx, time.Now().Add(-p.Retention))
+		if err != nil && ctx.Err() == nil {
+			log.Println("Trash purger: ", err)
+		} else if result.Todos > 0 || result.TodoDetails > 0 {
+			log.Printf("Purged %d todos and %d todo details from the trash\n", result.Todos, result.TodoDetails)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/yogisyo16/root-aura-service/services"
+)
+
+// Rebalancer gives lists whose ranks grew too long, or that hold items from
+// before ranks, fresh evenly spread ranks
+type Rebalancer struct {
+	Todos    services.Todo
+	Subtasks services.Subtask
+	Interval time.Duration
+}
+
+func NewRebalancer(todos services.Todo, subtasks services.Subtask) *Rebalancer {
+	return &Rebalancer{
+		Todos:    todos,
+		Subtasks: subtasks,
+		Interval: time.Hour,
+	}
+}
+
+// Run rebalances every Interval until ctx is cancelled
+func (b *Rebalancer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+
+	for {
+		b.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce rebalances the todo lists and subtask lists that need it
+func (b *Rebalancer) RunOnce(ctx context.Context) {
+	todos, err := b.Todos.RebalanceTodoLists(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Println("Rebalancer: ", err)
+	}
+	subtasks, err := b.Subtasks.RebalanceSubtaskLists(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Println("Rebalancer: ", err)
+	}
+	if todos > 0 || subtasks > 0 {
+		log.Printf("Rebalanced %d todo lists and %d subtask lists\n", todos, subtasks)
+	}
+}
@@ -0,0 +1,136 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/yogisyo16/root-aura-service/notifier"
+	"github.com/yogisyo16/root-aura-service/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// trashedReminderDelay is how long the reminder of a trashed todo waits
+// before it is looked at again, it is sent once the todo is restored
+const trashedReminderDelay = time.Hour
+
+// errTodoTrashed is returned by deliver for a todo in the trash
+var errTodoTrashed = errors.New("todo is in the trash")
+
+// ReminderScheduler delivers due reminders. Any number of API instances can
+// run one, a reminder is leased to one instance before it is delivered.
+type ReminderScheduler struct {
+	Reminders services.Reminder
+	Todos     services.Todo
+	Notifier  notifier.Notifier
+	// Owner identifies this instance in reminder leases
+	Owner string
+	// Interval is how often due reminders are looked up, Lease how long an
+	// instance holds a reminder and so the delay before a failed delivery
+	// is retried
+	Interval time.Duration
+	Lease    time.Duration
+	// BatchSize caps the reminders delivered per tick
+	BatchSize int
+}
+
+func NewReminderScheduler(reminders services.Reminder, todos services.Todo, n notifier.Notifier) *ReminderScheduler {
+	host, _ := os.Hostname()
+	return &ReminderScheduler{
+		Reminders: reminders,
+		Todos:     todos,
+		Notifier:  n,
+		Owner:     fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex()),
+		Interval:  30 * time.Second,
+		Lease:     2 * time.Minute,
+		BatchSize: 100,
+	}
+}
+
+// Run delivers due reminders every Interval until ctx is cancelled
+func (s *ReminderScheduler) Run(ctx context.Context) {
+	log.Printf("Reminder scheduler %s started\n", s.Owner)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Println("Reminder scheduler: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce delivers up to BatchSize due reminders and returns how many were
+// sent
+func (s *ReminderScheduler) RunOnce(ctx context.Context) (int, error) {
+	sent := 0
+	for i := 0; i < s.BatchSize; i++ {
+		reminder, err := s.Reminders.ClaimDueReminder(ctx, s.Owner, time.Now(), s.Lease)
+		if errors.Is(err, services.ErrReminderNotFound) {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		deliveryErr := s.deliver(ctx, reminder)
+		if errors.Is(deliveryErr, errTodoTrashed) {
+			if err := s.Reminders.PostponeReminder(ctx, reminder, s.Owner, time.Now().Add(trashedReminderDelay)); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		if deliveryErr != nil {
+			log.Printf("Could not deliver reminder %s: %v\n", reminder.ID, deliveryErr)
+		} else {
+			sent++
+		}
+		if err := s.Reminders.CompleteReminder(ctx, reminder, s.Owner, deliveryErr); err != nil {
+			log.Println(err)
+		}
+	}
+	return sent, nil
+}
+
+// deliver sends one reminder. Reminders of completed todos, and of todos
+// purged for good, are dropped without a notification; those of todos in
+// the trash wait with errTodoTrashed in case the todo is restored.
+func (s *ReminderScheduler) deliver(ctx context.Context, reminder services.Reminder) error {
+	todo, err := s.Todos.GetTodoById(reminder.TodoID.Hex())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		trashed, err := s.Todos.TodoInTrash(reminder.TodoID.Hex())
+		if err != nil {
+			return err
+		}
+		if trashed {
+			return errTodoTrashed
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if todo.Completed {
+		return nil
+	}
+
+	body := todo.Task
+	if todo.DateDue != nil {
+		body = fmt.Sprintf("%s is due %s", todo.Task, todo.DateDue.Format(time.RFC1123))
+	}
+	return s.Notifier.Notify(ctx, notifier.Notification{
+		UserID:  reminder.UserID.Hex(),
+		TodoID:  todo.ID,
+		Subject: "Reminder: " + todo.Task,
+		Body:    body,
+	})
+}
@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Activity is one recorded change to a todo or its details. Activities are
+// only ever appended, never edited.
+type Activity struct {
+	ID      string             `json:"id,omitempty" bson:"_id,omitempty"`
+	TodoID  primitive.ObjectID `json:"todo_id" bson:"_todo_id"`
+	UserID  primitive.ObjectID `json:"user_id" bson:"_user_id"`
+	Action  string             `json:"action" bson:"_action"`
+	Target  string             `json:"target" bson:"_target"`
+	Changes []FieldChange      `json:"changes" bson:"_changes"`
+	// The todo, or its details, as they were right after the change.
+	// Reverting to the activity restores them.
+	Snapshot        *Todo        `json:"-" bson:"_snapshot,omitempty"`
+	DetailsSnapshot *TodoDetails `json:"-" bson:"_details_snapshot,omitempty"`
+	CreatedAt       time.Time    `json:"created_at" bson:"_created_at"`
+	// TodoPurgedAt is set once the todo was purged, its history is kept
+	TodoPurgedAt *time.Time `json:"-" bson:"_todo_purged_at,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// FieldChange is the value of one field before and after a change
+type FieldChange struct {
+	Field string      `json:"field" bson:"_field"`
+	From  interface{} `json:"from" bson:"_from"`
+	To    interface{} `json:"to" bson:"_to"`
+}
+
+// ActivityPage is one page of the activity of a todo, Next is the cursor
+// of the page after it
+type ActivityPage struct {
+	Items []Activity `json:"items"`
+	Next  string     `json:"next,omitempty"`
+}
+
+// What an activity is about
+const (
+	ActivityTargetTodo        = "todo"
+	ActivityTargetTodoDetails = "todo_details"
+)
+
+const (
+	DefaultActivityPageSize = 50
+	MaxActivityPageSize     = 100
+)
+
+var ErrActivityNotFound = errors.New("activity not found")
+
+func returnActivitiesCollection(collection string) *mongo.Collection {
+	return client.Database("todos_db").Collection(collection)
+}
+
+// activityTime keeps times comparable and readable in the change list
+func activityTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func activityID(id *primitive.ObjectID) interface{} {
+	if id == nil {
+		return nil
+	}
+	return id.Hex()
+}
+
+func activityIDs(ids []primitive.ObjectID) interface{} {
+	if len(ids) == 0 {
+		return nil
+	}
+	hexes := make([]string, len(ids))
+	for i, id := range ids {
+		hexes[i] = id.Hex()
+	}
+	return hexes
+}
+
+// todoFields are the recorded fields of a todo, in the order changes are
+// listed
+func todoFields(todo *Todo) []FieldChange {
+	if todo == nil {
+		return nil
+	}
+	var rrule interface{}
+	if todo.Recurrence != nil {
+		rrule = todo.Recurrence.RRule
+	}
+	return []FieldChange{
+		{Field: "task", To: todo.Task},
+		{Field: "date_start", To: activityTime(todo.DateStart)},
+		{Field: "date_due", To: activityTime(todo.DateDue)},
+		{Field: "completed", To: todo.Completed},
+		{Field: "auto_complete", To: todo.AutoComplete},
+		{Field: "project_id", To: activityID(todo.ProjectID)},
+		{Field: "tag_ids", To: activityIDs(todo.TagIDs)},
+		{Field: "blocked_by", To: activityIDs(todo.BlockedBy)},
+		{Field: "recurrence", To: rrule},
+		{Field: "rank", To: todo.Rank},
+		{Field: "archived_at", To: activityTime(todo.ArchivedAt)},
+		{Field: "deleted_at", To: activityTime(todo.DeletedAt)},
+	}
+}
+
+func detailsFields(details *TodoDetails) []FieldChange {
+	if details == nil {
+		return nil
+	}
+	return []FieldChange{
+		{Field: "task_details", To: details.TaskDetails},
+		{Field: "notes_details", To: details.NotesDetails},
+		{Field: "status_details", To: details.StatusDetails},
+		{Field: "priority_details", To: details.PriorityDetails},
+		{Field: "deleted_at", To: activityTime(details.DeletedAt)},
+	}
+}
+
+// diffFields lists the fields whose value differs between before and
+// after, a nil side has every field unset
+func diffFields(before, after []FieldChange) []FieldChange {
+	fields := after
+	if fields == nil {
+		fields = before
+	}
+	changes := []FieldChange{}
+	for i, field := range fields {
+		var from, to interface{}
+		if before != nil {
+			from = before[i].To
+		}
+		if after != nil {
+			to = after[i].To
+		}
+		if !reflect.DeepEqual(from, to) {
+			changes = append(changes, FieldChange{Field: field.Field, From: from, To: to})
+		}
+	}
+	return changes
+}
+
+func insertActivity(activity Activity) {
+	activity.CreatedAt = time.Now()
+	activity.SchemaVersion = CurrentSchemaVersion
+	if _, err := returnActivitiesCollection("activities").InsertOne(context.TODO(), activity); err != nil {
+		log.Println("Could not record activity: ", err)
+	}
+}
+
+// RecordActivity records what action changed on a todo. before is nil for
+// a new todo. Nothing is recorded when no recorded field changed.
+func (t *Todo) RecordActivity(userID primitive.ObjectID, action string, before *Todo, after Todo) {
+	changes := diffFields(todoFields(before), todoFields(&after))
+	if len(changes) == 0 {
+		return
+	}
+	insertActivity(Activity{
+		TodoID:   after.mongoID(),
+		UserID:   userID,
+		Action:   action,
+		Target:   ActivityTargetTodo,
+		Changes:  changes,
+		Snapshot: &after,
+	})
+}
+
+// RecordDetailsActivity records what action changed on the details of a
+// todo, before is nil for new details and after for deleted ones
+func (t *Todo) RecordDetailsActivity(userID primitive.ObjectID, todoID primitive.ObjectID, action string, before *TodoDetails, after *TodoDetails) {
+	changes := diffFields(detailsFields(before), detailsFields(after))
+	if len(changes) == 0 {
+		return
+	}
+	insertActivity(Activity{
+		TodoID:          todoID,
+		UserID:          userID,
+		Action:          action,
+		Target:          ActivityTargetTodoDetails,
+		Changes:         changes,
+		DetailsSnapshot: after,
+	})
+}
+
+// GetActivity returns up to limit activities of a todo, latest first,
+// starting after the activity with id after
+func (t *Todo) GetActivity(todoID string, after string, limit int) (ActivityPage, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return ActivityPage{}, ErrTodoNotFound
+	}
+
+	filter := bson.M{"_todo_id": todoOID}
+	if after != "" {
+		afterID, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return ActivityPage{}, ErrActivityNotFound
+		}
+		filter["_id"] = bson.M{"$lt": afterID}
+	}
+
+	// One more than asked tells whether there is a next page
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit + 1))
+	cursor, err := returnActivitiesCollection("activities").Find(context.TODO(), filter, opts)
+	if err != nil {
+		log.Println(err)
+		return ActivityPage{}, err
+	}
+
+	page := ActivityPage{Items: []Activity{}}
+	if err := cursor.All(context.TODO(), &page.Items); err != nil {
+		log.Println(err)
+		return ActivityPage{}, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.Next = page.Items[limit-1].ID
+	}
+	return page, nil
+}
+
+// RevertToActivity puts the todo, or its details, back the way they were
+// right after the activity with id activityID. Only content is reverted:
+// dependencies, order, archive and trash state stay as they are. The revert
+// is recorded as an activity itself. Reverting to a completed state fails
+// with ErrTodoBlocked while open todos block the todo, unless force is set.
+func (t *Todo) RevertToActivity(userID primitive.ObjectID, todoID string, activityID string, force bool) error {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+	mongoID, err := primitive.ObjectIDFromHex(activityID)
+	if err != nil {
+		return ErrActivityNotFound
+	}
+
+	var activity Activity
+	err = returnActivitiesCollection("activities").FindOne(context.TODO(), bson.M{"_id": mongoID, "_todo_id": todoOID}).Decode(&activity)
+	if err == mongo.ErrNoDocuments {
+		return ErrActivityNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if activity.Target == ActivityTargetTodoDetails {
+		return revertDetails(userID, todoOID, activity.DetailsSnapshot)
+	}
+	if activity.Snapshot == nil {
+		return ErrActivityNotFound
+	}
+	return t.revertTodo(userID, todoOID, *activity.Snapshot, force)
+}
+
+func (t *Todo) revertTodo(userID primitive.ObjectID, todoOID primitive.ObjectID, snapshot Todo, force bool) error {
+	collection := returnTodosCollection("todos")
+	var before Todo
+	err := collection.FindOne(context.TODO(), bson.M{"_id": todoOID, "_user_id": userID, "_deleted_at": nil}).Decode(&before)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+	if snapshot.Completed && !before.Completed && !force {
+		open, err := t.OpenBlockers(before)
+		if err != nil {
+			return err
+		}
+		if len(open) > 0 {
+			return ErrTodoBlocked
+		}
+	}
+
+	set := bson.M{
+		"_task":          snapshot.Task,
+		"_date_start":    snapshot.DateStart,
+		"_date_due":      snapshot.DateDue,
+		"_completed":     snapshot.Completed,
+		"_auto_complete": snapshot.AutoComplete,
+		"_tag_ids":       snapshot.TagIDs,
+		"_updated_at":    time.Now(),
+	}
+	unset := bson.M{}
+	if snapshot.CompletedAt != nil {
+		set["_completed_at"] = snapshot.CompletedAt
+	} else {
+		unset["_completed_at"] = ""
+	}
+	// A project deleted since then leaves the todo in the inbox
+	unset["_project_id"] = ""
+	if snapshot.ProjectID != nil {
+		count, err := returnProjectsCollection("projects").CountDocuments(context.TODO(), bson.M{"_id": *snapshot.ProjectID, "_user_id": userID})
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		if count > 0 {
+			set["_project_id"] = *snapshot.ProjectID
+			delete(unset, "_project_id")
+		}
+	}
+
+	var after Todo
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(context.TODO(), bson.M{"_id": todoOID}, bson.M{"$set": set, "$unset": unset, "$inc": incVersion}, opts).Decode(&after)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if before.DateDue == nil || after.DateDue == nil || !before.DateDue.Equal(*after.DateDue) {
+		var reminders Reminder
+		if err := reminders.RescheduleReminders(after.ID, after.DateDue); err != nil {
+			log.Println(err)
+		}
+	}
+
+	t.RecordActivity(userID, "reverted", &before, after)
+	return nil
+}
+
+func revertDetails(userID primitive.ObjectID, todoOID primitive.ObjectID, snapshot *TodoDetails) error {
+	var details TodoDetails
+	current, err := details.GetTodoDetailsByTodoId(todoOID.Hex())
+	if err != nil {
+		return err
+	}
+
+	var todos Todo
+	switch {
+	case snapshot == nil && current.ID == "":
+		return nil
+	case snapshot == nil:
+		if err := details.DeleteTodoDetails(current.ID, AnyVersion); err != nil {
+			return err
+		}
+		todos.RecordDetailsActivity(userID, todoOID, "reverted", &current, nil)
+		return nil
+	case current.ID == "":
+		restored := *snapshot
+		restored.TodoID = todoOID
+		if _, err := details.InsertTodoDetails(restored); err != nil {
+			return err
+		}
+		todos.RecordDetailsActivity(userID, todoOID, "reverted", nil, &restored)
+		return nil
+	}
+
+	restored := current
+	restored.TaskDetails = snapshot.TaskDetails
+	restored.NotesDetails = snapshot.NotesDetails
+	restored.StatusDetails = snapshot.StatusDetails
+	restored.PriorityDetails = snapshot.PriorityDetails
+	if err := details.UpdateTodoDetails(current.ID, restored, AnyVersion); err != nil {
+		return err
+	}
+	todos.RecordDetailsActivity(userID, todoOID, "reverted", &current, &restored)
+	return nil
+}
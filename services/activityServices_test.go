@@ -0,0 +1,86 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDiffFields(t *testing.T) {
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	project := primitive.NewObjectID()
+	base := Todo{Task: "Write report", Rank: "i"}
+
+	changed := base
+	changed.Task = "Write the report"
+	changed.DateDue = &due
+	changed.ProjectID = &project
+
+	tests := []struct {
+		name          string
+		before, after *Todo
+		want          []FieldChange
+	}{
+		{
+			name:   "nothing changed",
+			before: &base,
+			after:  &base,
+			want:   []FieldChange{},
+		},
+		{
+			name:   "changed fields in order",
+			before: &base,
+			after:  &changed,
+			want: []FieldChange{
+				{Field: "task", From: "Write report", To: "Write the report"},
+				{Field: "date_due", From: nil, To: "2026-03-01T09:00:00Z"},
+				{Field: "project_id", From: nil, To: project.Hex()},
+			},
+		},
+		{
+			name:   "cleared fields",
+			before: &changed,
+			after:  &base,
+			want: []FieldChange{
+				{Field: "task", From: "Write the report", To: "Write report"},
+				{Field: "date_due", From: "2026-03-01T09:00:00Z", To: nil},
+				{Field: "project_id", From: project.Hex(), To: nil},
+			},
+		},
+		{
+			name:   "new todo",
+			before: nil,
+			after:  &base,
+			want: []FieldChange{
+				{Field: "task", From: nil, To: "Write report"},
+				{Field: "completed", From: nil, To: false},
+				{Field: "auto_complete", From: nil, To: false},
+				{Field: "rank", From: nil, To: "i"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffFields(todoFields(tt.before), todoFields(tt.after))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffFieldsDeletedDetails(t *testing.T) {
+	details := TodoDetails{TaskDetails: "Notes", StatusDetails: StatusTodo, PriorityDetails: PriorityMedium}
+	got := diffFields(detailsFields(&details), detailsFields(nil))
+	want := []FieldChange{
+		{Field: "task_details", From: "Notes", To: nil},
+		{Field: "notes_details", From: "", To: nil},
+		{Field: "status_details", From: StatusTodo, To: nil},
+		{Field: "priority_details", From: PriorityMedium, To: nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffFields() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrTodoNotCompleted = errors.New("only completed todos can be archived")
+
+// ArchiveMonth counts the archived todos completed in one month
+type ArchiveMonth struct {
+	Month string `json:"month" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// ArchiveTodo archives one of the user's completed todos
+func (t *Todo) ArchiveTodo(userID primitive.ObjectID, id string) error {
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+	collection := returnTodosCollection("todos")
+
+	var todo Todo
+	err = collection.FindOne(context.TODO(), bson.M{"_id": mongoID, "_user_id": userID, "_deleted_at": nil}).Decode(&todo)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+	if !todo.Completed {
+		return ErrTodoNotCompleted
+	}
+	if todo.ArchivedAt != nil {
+		return nil
+	}
+
+	_, err = collection.UpdateOne(context.TODO(),
+		bson.M{"_id": mongoID, "_completed": true},
+		bson.M{"$set": bson.M{"_archived_at": time.Now()}, "$inc": incVersion},
+	)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// UnarchiveTodo puts an archived todo back into the listings
+func (t *Todo) UnarchiveTodo(userID primitive.ObjectID, id string) error {
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+
+	res, err := returnTodosCollection("todos").UpdateOne(context.TODO(),
+		bson.M{"_id": mongoID, "_user_id": userID, "_deleted_at": nil},
+		bson.M{"$unset": bson.M{"_archived_at": ""}, "$inc": incVersion},
+	)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTodoNotFound
+	}
+	return nil
+}
+
+// ArchiveCompletedBefore archives every todo completed before "before" and
+// returns how many were archived
+func (t *Todo) ArchiveCompletedBefore(ctx context.Context, before time.Time) (int64, error) {
+	res, err := returnTodosCollection("todos").UpdateMany(ctx,
+		bson.M{
+			"_completed":    true,
+			"_completed_at": bson.M{"$lt": before},
+			"_archived_at":  nil,
+			"_deleted_at":   nil,
+		},
+		bson.M{"$set": bson.M{"_archived_at": time.Now()}, "$inc": incVersion},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// ArchiveHistory counts the user's archived todos per month they were
+// completed in, latest month first. Months are those of loc.
+func (t *Todo) ArchiveHistory(userID primitive.ObjectID, loc *time.Location) ([]ArchiveMonth, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"_user_id": userID, "_archived_at": bson.M{"$ne": nil}, "_deleted_at": nil}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format":   "%Y-%m",
+				"date":     bson.M{"$ifNull": bson.A{"$_completed_at", "$_archived_at"}},
+				"timezone": loc.String(),
+			}},
+			"count": bson.M{"$sum": 1},
+		}},
+		bson.M{"$sort": bson.M{"_id": -1}},
+	}
+	cursor, err := returnTodosCollection("todos").Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	months := []ArchiveMonth{}
+	if err := cursor.All(context.TODO(), &months); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return months, nil
+}
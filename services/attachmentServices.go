@@ -0,0 +1,240 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/yogisyo16/root-aura-service/blobstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Attachment is the metadata of a file attached to a todo, the content
+// lives in the blob store under Key
+type Attachment struct {
+	ID          string             `json:"id,omitempty" bson:"_id,omitempty"`
+	TodoID      primitive.ObjectID `json:"todo_id" bson:"_todo_id"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"_user_id"`
+	Filename    string             `json:"filename" bson:"_filename"`
+	ContentType string             `json:"content_type" bson:"_content_type"`
+	Size        int64              `json:"size" bson:"_size"`
+	Key         string             `json:"-" bson:"_key"`
+	CreatedAt   time.Time          `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// MaxAttachmentSize is the largest file that can be attached, in bytes
+const MaxAttachmentSize = 10 << 20
+
+// AttachmentTypes are the content types files can have, detected from the
+// content rather than taken from the upload
+var AttachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+)
+
+var blobs blobstore.Store
+
+func NewAttachmentService(mongo *mongo.Client, store blobstore.Store) Attachment {
+	client = mongo
+	blobs = store
+	return Attachment{}
+}
+
+func returnAttachmentsCollection(collection string) *mongo.Collection {
+	return client.Database("todos_db").Collection(collection)
+}
+
+// GetAttachmentsByTodoId lists the attachments of a todo, oldest first
+func (a *Attachment) GetAttachmentsByTodoId(todoID string) ([]Attachment, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := returnAttachmentsCollection("attachments").Find(context.TODO(), bson.M{"_todo_id": todoOID}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	attachments := []Attachment{}
+	if err := cursor.All(context.TODO(), &attachments); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// GetAttachment loads one attachment of a todo
+func (a *Attachment) GetAttachment(todoID string, id string) (Attachment, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+
+	var attachment Attachment
+	err = returnAttachmentsCollection("attachments").FindOne(context.TODO(), bson.M{"_id": mongoID, "_todo_id": todoOID}).Decode(&attachment)
+	if err == mongo.ErrNoDocuments {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// OpenAttachment opens the content of an attachment, the caller closes it
+func (a *Attachment) OpenAttachment(ctx context.Context, attachment Attachment) (io.ReadCloser, error) {
+	content, err := blobs.Get(ctx, attachment.Key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	return content, err
+}
+
+// cleanFilename keeps the last element of an uploaded file name
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+// InsertAttachment stores content and records it as an attachment of the
+// todo in entry. The type is sniffed from the content, anything larger
+// than MaxAttachmentSize is refused.
+func (a *Attachment) InsertAttachment(ctx context.Context, entry Attachment, content io.Reader) (Attachment, error) {
+	buffered := bufio.NewReaderSize(content, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return Attachment{}, err
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !AttachmentTypes[contentType] {
+		return Attachment{}, ErrAttachmentType
+	}
+
+	mongoID := primitive.NewObjectID()
+	attachment := Attachment{
+		ID:          mongoID.Hex(),
+		TodoID:      entry.TodoID,
+		UserID:      entry.UserID,
+		Filename:    cleanFilename(entry.Filename),
+		ContentType: contentType,
+		Key:         entry.TodoID.Hex() + "/" + mongoID.Hex(),
+		CreatedAt:   time.Now(),
+
+		SchemaVersion: CurrentSchemaVersion,
+	}
+
+	// One byte past the limit tells a file of exactly the limit from a
+	// larger one
+	attachment.Size, err = blobs.Put(ctx, attachment.Key, io.LimitReader(buffered, MaxAttachmentSize+1))
+	if err != nil {
+		log.Println(err)
+		return Attachment{}, err
+	}
+	if attachment.Size > MaxAttachmentSize {
+		deleteBlobs([]string{attachment.Key})
+		return Attachment{}, ErrAttachmentTooLarge
+	}
+
+	// ID is a string in the model, the stored _id an ObjectID
+	_, err = returnAttachmentsCollection("attachments").InsertOne(ctx, bson.D{
+		{Key: "_id", Value: mongoID},
+		{Key: "_todo_id", Value: attachment.TodoID},
+		{Key: "_user_id", Value: attachment.UserID},
+		{Key: "_filename", Value: attachment.Filename},
+		{Key: "_content_type", Value: attachment.ContentType},
+		{Key: "_size", Value: attachment.Size},
+		{Key: "_key", Value: attachment.Key},
+		{Key: "_created_at", Value: attachment.CreatedAt},
+		{Key: "_schema_version", Value: attachment.SchemaVersion},
+	})
+	if err != nil {
+		log.Println("Error: ", err)
+		deleteBlobs([]string{attachment.Key})
+		return Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// DeleteAttachment removes an attachment of a todo and its content
+func (a *Attachment) DeleteAttachment(todoID string, id string) error {
+	attachment, err := a.GetAttachment(todoID, id)
+	if err != nil {
+		return err
+	}
+	mongoID, _ := primitive.ObjectIDFromHex(attachment.ID)
+
+	_, err = returnAttachmentsCollection("attachments").DeleteOne(context.TODO(), bson.M{"_id": mongoID})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	deleteBlobs([]string{attachment.Key})
+	return nil
+}
+
+// attachmentKeys returns the blob keys of the attachments of a todo
+func attachmentKeys(ctx context.Context, todoID primitive.ObjectID) []string {
+	opts := options.Find().SetProjection(bson.M{"_key": 1})
+	cursor, err := returnAttachmentsCollection("attachments").Find(ctx, bson.M{"_todo_id": todoID}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	var attachments []Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	keys := make([]string, 0, len(attachments))
+	for _, attachment := range attachments {
+		keys = append(keys, attachment.Key)
+	}
+	return keys
+}
+
+// deleteBlobs removes contents whose metadata is gone. Failures are only
+// logged, an orphaned blob costs space but is never served.
+func deleteBlobs(keys []string) {
+	if blobs == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := blobs.Delete(context.Background(), key); err != nil {
+			log.Println("Could not delete blob ", key, ": ", err)
+		}
+	}
+}
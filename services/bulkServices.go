@@ -0,0 +1,517 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operations a bulk update applies to every todo
+const (
+	BulkComplete    = "complete"
+	BulkUncomplete  = "uncomplete"
+	BulkDelete      = "delete"
+	BulkSetDueDate  = "set_due_date"
+	BulkSetPriority = "set_priority"
+	BulkMove        = "move"
+)
+
+// What a bulk update did to one todo
+const (
+	BulkUpdated   = "updated"
+	BulkUnchanged = "unchanged"
+	BulkNotFound  = "not_found"
+	BulkBlocked   = "blocked"
+	BulkInvalid   = "invalid"
+	BulkFailed    = "failed"
+)
+
+// MaxBulkTodos is how many todos one bulk update takes
+const MaxBulkTodos = 100
+
+var ErrUnknownBulkOperation = errors.New("unknown bulk operation")
+
+// BulkOperation is one operation applied to many todos
+type BulkOperation struct {
+	Op string
+	// DateDue is what set_due_date sets, nil clears the due date
+	DateDue *time.Time
+	// Priority is what set_priority gives the details
+	Priority string
+	// ProjectID is where move moves to, nil is the inbox
+	ProjectID *primitive.ObjectID
+	// Force completes todos that open todos still block
+	Force bool
+}
+
+// BulkResult is what a bulk update did to one todo
+type BulkResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulkWrite is what a bulk update writes for one todo
+type bulkWrite struct {
+	todo    Todo
+	model   mongo.WriteModel
+	details []mongo.WriteModel
+}
+
+// bulkActions are the activities bulk updates are recorded as
+var bulkActions = map[string]string{
+	BulkComplete:    "completed",
+	BulkUncomplete:  "reopened",
+	BulkDelete:      "trashed",
+	BulkSetDueDate:  "updated",
+	BulkSetPriority: "details_updated",
+	BulkMove:        "project_changed",
+}
+
+// BulkUpdateTodos applies op to the user's todos with the given ids with
+// one bulk write per collection. Every id gets a result, todos of other
+// users are not found. Repeated ids count once.
+func (t *Todo) BulkUpdateTodos(userID primitive.ObjectID, ids []string, op BulkOperation) ([]BulkResult, error) {
+	if _, ok := bulkActions[op.Op]; !ok {
+		return nil, ErrUnknownBulkOperation
+	}
+
+	results, oids := bulkResults(ids)
+	byID := make(map[string]*BulkResult, len(results))
+	for i := range results {
+		byID[results[i].ID] = &results[i]
+	}
+
+	todos, err := findTodos(bson.M{"_id": bson.M{"$in": oids}, "_user_id": userID, "_deleted_at": nil})
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]Todo{}
+	todoIDs := bson.A{}
+	for _, todo := range todos {
+		found[todo.ID] = todo
+		todoIDs = append(todoIDs, todo.mongoID())
+		byID[todo.ID].Status = BulkUnchanged
+	}
+	// Keep the order the todos were asked for in
+	todos = todos[:0]
+	for _, result := range results {
+		if todo, ok := found[result.ID]; ok {
+			todos = append(todos, todo)
+		}
+	}
+
+	detailsBefore, err := detailsByTodo(bson.M{"_todo_id": bson.M{"$in": todoIDs}, "_deleted_at": nil})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	writes, err := planBulkWrites(userID, todos, detailsBefore, op, now, byID)
+	if err != nil {
+		return nil, err
+	}
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	written := runBulkWrites(writes, op, byID)
+	t.afterBulkWrites(userID, written, detailsBefore, op, now)
+	return results, nil
+}
+
+// bulkResults starts a result for every id asked for and returns the ids to
+// look up. Ids are keyed the way they are read back, as lowercase hex, so
+// the same todo asked for in another case counts once. Ids that are no
+// ObjectID are invalid.
+func bulkResults(ids []string) ([]BulkResult, bson.A) {
+	results := []BulkResult{}
+	seen := map[string]bool{}
+	oids := bson.A{}
+	for _, id := range ids {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			if !seen[id] {
+				seen[id] = true
+				results = append(results, BulkResult{ID: id, Status: BulkInvalid, Error: "Invalid todo id"})
+			}
+			continue
+		}
+		if seen[oid.Hex()] {
+			continue
+		}
+		seen[oid.Hex()] = true
+		results = append(results, BulkResult{ID: oid.Hex(), Status: BulkNotFound})
+		oids = append(oids, oid)
+	}
+	return results, oids
+}
+
+// planBulkWrites decides what op writes for every todo, todos it cannot
+// or need not change get their result here
+func planBulkWrites(userID primitive.ObjectID, todos []Todo, details map[string]TodoDetails, op BulkOperation, now time.Time, byID map[string]*BulkResult) ([]bulkWrite, error) {
+	var writes []bulkWrite
+	switch op.Op {
+	case BulkComplete, BulkUncomplete:
+		completed := op.Op == BulkComplete
+		blocked := map[string]bool{}
+		if completed && !op.Force {
+			var err error
+			if blocked, err = blockedTodos(todos); err != nil {
+				return nil, err
+			}
+		}
+		for _, todo := range todos {
+			if todo.Completed == completed {
+				continue
+			}
+			if blocked[todo.ID] {
+				byID[todo.ID].Status = BulkBlocked
+				byID[todo.ID].Error = "Todo is blocked by open todos"
+				continue
+			}
+			update := bson.M{"$set": bson.M{"_completed": true, "_completed_at": now, "_updated_at": now}, "$inc": incVersion}
+			if !completed {
+				update = bson.M{"$set": bson.M{"_completed": false, "_updated_at": now}, "$unset": bson.M{"_completed_at": "", "_archived_at": ""}, "$inc": incVersion}
+			}
+			writes = append(writes, bulkWrite{
+				todo:  todo,
+				model: mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": todo.mongoID(), "_completed": !completed}).SetUpdate(update),
+			})
+		}
+
+	case BulkDelete:
+		// Every todo and its details get the same timestamp, restoring a
+		// todo brings back what was trashed with it
+		for _, todo := range todos {
+			writes = append(writes, bulkWrite{
+				todo: todo,
+				model: mongo.NewUpdateOneModel().
+					SetFilter(bson.M{"_id": todo.mongoID(), "_deleted_at": nil}).
+					SetUpdate(bson.M{"$set": bson.M{"_deleted_at": now}, "$inc": incVersion}),
+				details: []mongo.WriteModel{mongo.NewUpdateManyModel().
+					SetFilter(bson.M{"_todo_id": todo.mongoID(), "_deleted_at": nil}).
+					SetUpdate(bson.M{"$set": bson.M{"_deleted_at": now}})},
+			})
+		}
+
+	case BulkSetDueDate:
+		for _, todo := range todos {
+			if sameTime(todo.DateDue, op.DateDue) {
+				continue
+			}
+			if op.DateDue != nil && todo.DateStart != nil && todo.DateStart.After(*op.DateDue) {
+				byID[todo.ID].Status = BulkInvalid
+				byID[todo.ID].Error = "Start date cannot be after due date"
+				continue
+			}
+			update := bson.M{"$set": bson.M{"_date_due": op.DateDue, "_updated_at": now}, "$inc": incVersion}
+			if op.DateDue == nil {
+				update = bson.M{"$unset": bson.M{"_date_due": ""}, "$set": bson.M{"_updated_at": now}, "$inc": incVersion}
+			}
+			writes = append(writes, bulkWrite{
+				todo:  todo,
+				model: mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": todo.mongoID()}).SetUpdate(update),
+			})
+		}
+
+	case BulkSetPriority:
+		var missing bson.A
+		for _, todo := range todos {
+			current, ok := details[todo.ID]
+			if ok && current.PriorityDetails == op.Priority {
+				continue
+			}
+			write := bulkWrite{
+				todo:  todo,
+				model: mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": todo.mongoID()}).SetUpdate(bson.M{"$inc": incVersion}),
+			}
+			if ok {
+				mongoID, _ := primitive.ObjectIDFromHex(current.ID)
+				write.details = []mongo.WriteModel{mongo.NewUpdateOneModel().
+					SetFilter(bson.M{"_id": mongoID, "_deleted_at": nil}).
+					SetUpdate(bson.M{"$set": bson.M{"_priority_details": op.Priority, "_updated_at": now}, "$inc": incVersion})}
+			} else {
+				// Todos without details get them, with the status their
+				// completion state implies
+				status := StatusTodo
+				if todo.Completed {
+					status = StatusDone
+				}
+				missing = append(missing, todo.mongoID())
+				write.details = []mongo.WriteModel{mongo.NewInsertOneModel().SetDocument(TodoDetails{
+					TodoID:          todo.mongoID(),
+					StatusDetails:   status,
+					PriorityDetails: op.Priority,
+					CreatedAt:       now,
+					UpdatedAt:       now,
+					Version:         1,
+
+					SchemaVersion: CurrentSchemaVersion,
+				})}
+			}
+			writes = append(writes, write)
+		}
+		// A todo has one set of details, todos whose details are in the
+		// trash get none, like InsertTodoDetails
+		if len(missing) > 0 {
+			trashed, err := returnTodoDetailsCollection("todo_details").Distinct(context.TODO(), "_todo_id",
+				bson.M{"_todo_id": bson.M{"$in": missing}, "_deleted_at": bson.M{"$ne": nil}})
+			if err != nil {
+				log.Println(err)
+				return nil, err
+			}
+			inTrash := map[primitive.ObjectID]bool{}
+			for _, id := range trashed {
+				if oid, ok := id.(primitive.ObjectID); ok {
+					inTrash[oid] = true
+				}
+			}
+			kept := writes[:0]
+			for _, write := range writes {
+				if _, ok := details[write.todo.ID]; !ok && inTrash[write.todo.mongoID()] {
+					byID[write.todo.ID].Status = BulkInvalid
+					byID[write.todo.ID].Error = "Todo details are in the trash, restore them first"
+					continue
+				}
+				kept = append(kept, write)
+			}
+			writes = kept
+		}
+
+	case BulkMove:
+		var moving []Todo
+		for _, todo := range todos {
+			if !sameProject(todo.ProjectID, op.ProjectID) {
+				moving = append(moving, todo)
+			}
+		}
+		if len(moving) == 0 {
+			break
+		}
+		// The todos go to the end of the new list, in the order asked for
+		last := lastRank(returnTodosCollection("todos"), todoListFilter(userID, op.ProjectID))
+		ranks := SpreadRanks(len(moving))
+		for i, todo := range moving {
+			update := bson.M{"$set": bson.M{"_project_id": op.ProjectID, "_rank": last + ranks[i], "_updated_at": now}, "$inc": incVersion}
+			if op.ProjectID == nil {
+				update = bson.M{"$unset": bson.M{"_project_id": ""}, "$set": bson.M{"_rank": last + ranks[i], "_updated_at": now}, "$inc": incVersion}
+			}
+			writes = append(writes, bulkWrite{
+				todo:  todo,
+				model: mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": todo.mongoID()}).SetUpdate(update),
+			})
+		}
+	}
+	return writes, nil
+}
+
+// runBulkWrites writes the todos, then the details of the todos that were
+// written. It returns the writes that went through.
+func runBulkWrites(writes []bulkWrite, op BulkOperation, byID map[string]*BulkResult) []bulkWrite {
+	models := make([]mongo.WriteModel, len(writes))
+	for i, write := range writes {
+		models[i] = write.model
+	}
+	failed := bulkWriteFailures(returnTodosCollection("todos"), models)
+
+	var written []bulkWrite
+	var detailModels []mongo.WriteModel
+	var detailTodos []int
+	for i, write := range writes {
+		if err, ok := failed[i]; ok {
+			byID[write.todo.ID].Status = BulkFailed
+			byID[write.todo.ID].Error = err
+			continue
+		}
+		for _, model := range write.details {
+			detailModels = append(detailModels, model)
+			detailTodos = append(detailTodos, len(written))
+		}
+		written = append(written, write)
+		byID[write.todo.ID].Status = BulkUpdated
+	}
+	if len(detailModels) == 0 {
+		return written
+	}
+
+	failed = bulkWriteFailures(returnTodoDetailsCollection("todo_details"), detailModels)
+	for i, err := range failed {
+		write := written[detailTodos[i]]
+		log.Println("Could not write details of ", write.todo.ID, ": ", err)
+		// The todo itself was written, unless only its details were asked
+		// for the client has to know they disagree now
+		byID[write.todo.ID].Status = BulkFailed
+		byID[write.todo.ID].Error = err
+		if op.Op != BulkSetPriority {
+			byID[write.todo.ID].Error = "Todo was updated but its details were not: " + err
+		}
+	}
+	return written
+}
+
+// bulkWriteFailures runs models unordered and returns the error of every
+// model that failed by its index. A failure of the whole write fails
+// every model.
+func bulkWriteFailures(collection *mongo.Collection, models []mongo.WriteModel) map[int]string {
+	failed := map[int]string{}
+	_, err := collection.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false))
+	if err == nil {
+		return failed
+	}
+	log.Println(err)
+
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = writeErr.Message
+		}
+		return failed
+	}
+	for i := range models {
+		failed[i] = "Failed to write todo"
+	}
+	return failed
+}
+
+// afterBulkWrites does what the single todo endpoints do after a change:
+// keep details and reminders in line, schedule next occurrences and
+// record the activity
+func (t *Todo) afterBulkWrites(userID primitive.ObjectID, written []bulkWrite, detailsBefore map[string]TodoDetails, op BulkOperation, now time.Time) {
+	ids := bson.A{}
+	for _, write := range written {
+		ids = append(ids, write.todo.mongoID())
+	}
+
+	var details TodoDetails
+	var reminders Reminder
+	for _, write := range written {
+		switch op.Op {
+		case BulkComplete, BulkUncomplete:
+			if err := details.SyncStatusWithCompletion(write.todo.ID, op.Op == BulkComplete); err != nil {
+				log.Println(err)
+			}
+		case BulkSetDueDate:
+			if err := reminders.RescheduleReminders(write.todo.ID, op.DateDue); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	// Trashed todos and details are read back too, the trashing is what
+	// gets recorded
+	after, err := findTodos(bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return
+	}
+	detailsAfter, err := detailsByTodo(bson.M{"_todo_id": bson.M{"$in": ids}, "$or": bson.A{
+		bson.M{"_deleted_at": nil},
+		bson.M{"_deleted_at": now},
+	}})
+	if err != nil {
+		return
+	}
+
+	action := bulkActions[op.Op]
+	afterByID := map[string]Todo{}
+	for _, todo := range after {
+		afterByID[todo.ID] = todo
+	}
+	for _, write := range written {
+		todo, ok := afterByID[write.todo.ID]
+		if !ok {
+			continue
+		}
+		before := write.todo
+		t.RecordActivity(userID, action, &before, todo)
+		t.RecordDetailsActivity(userID, todo.mongoID(), action, detailsRef(detailsBefore, todo.ID), detailsRef(detailsAfter, todo.ID))
+
+		if op.Op == BulkComplete {
+			next, created, err := t.CreateNextOccurrence(todo)
+			if err != nil {
+				log.Println("Could not create next occurrence: ", err)
+			} else if created {
+				t.RecordActivity(userID, "created", nil, next)
+			}
+		}
+	}
+}
+
+// blockedTodos returns which of todos open todos still block
+func blockedTodos(todos []Todo) (map[string]bool, error) {
+	blockers := bson.A{}
+	for _, todo := range todos {
+		for _, id := range todo.BlockedBy {
+			blockers = append(blockers, id)
+		}
+	}
+	blocked := map[string]bool{}
+	if len(blockers) == 0 {
+		return blocked, nil
+	}
+
+	open, err := findTodos(bson.M{"_id": bson.M{"$in": blockers}, "_completed": false, "_deleted_at": nil})
+	if err != nil {
+		return nil, err
+	}
+	isOpen := map[primitive.ObjectID]bool{}
+	for _, todo := range open {
+		isOpen[todo.mongoID()] = true
+	}
+	for _, todo := range todos {
+		for _, id := range todo.BlockedBy {
+			if isOpen[id] {
+				blocked[todo.ID] = true
+			}
+		}
+	}
+	return blocked, nil
+}
+
+// detailsByTodo loads the details matching filter keyed by their todo
+func detailsByTodo(filter bson.M) (map[string]TodoDetails, error) {
+	cursor, err := returnTodoDetailsCollection("todo_details").Find(context.TODO(), filter)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var details []TodoDetails
+	if err := cursor.All(context.TODO(), &details); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	byTodo := make(map[string]TodoDetails, len(details))
+	for _, detail := range details {
+		byTodo[detail.TodoID.Hex()] = detail
+	}
+	return byTodo, nil
+}
+
+func detailsRef(details map[string]TodoDetails, todoID string) *TodoDetails {
+	detail, ok := details[todoID]
+	if !ok {
+		return nil
+	}
+	return &detail
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func sameProject(a, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBulkResults(t *testing.T) {
+	const id = "65ab12cd34ef56ab78cd90ef"
+	const upper = "65AB12CD34EF56AB78CD90EF"
+	const other = "65ab12cd34ef56ab78cd90f0"
+
+	tests := []struct {
+		name    string
+		ids     []string
+		results []BulkResult
+		lookups int
+	}{
+		{
+			name:    "mixed case counts once",
+			ids:     []string{upper, id},
+			results: []BulkResult{{ID: id, Status: BulkNotFound}},
+			lookups: 1,
+		},
+		{
+			name:    "duplicates count once",
+			ids:     []string{id, other, id},
+			results: []BulkResult{{ID: id, Status: BulkNotFound}, {ID: other, Status: BulkNotFound}},
+			lookups: 2,
+		},
+		{
+			name: "unparseable ids are invalid",
+			ids:  []string{"nope", id, "nope"},
+			results: []BulkResult{
+				{ID: "nope", Status: BulkInvalid, Error: "Invalid todo id"},
+				{ID: id, Status: BulkNotFound},
+			},
+			lookups: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, oids := bulkResults(tt.ids)
+			if !reflect.DeepEqual(results, tt.results) {
+				t.Errorf("results = %+v, want %+v", results, tt.results)
+			}
+			if len(oids) != tt.lookups {
+				t.Errorf("looked up %d ids, want %d", len(oids), tt.lookups)
+			}
+		})
+	}
+}
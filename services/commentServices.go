@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Comment is a Markdown comment on a todo. ParentID makes it a reply to
+// another comment of the same todo.
+type Comment struct {
+	ID       string              `json:"id,omitempty" bson:"_id,omitempty"`
+	TodoID   primitive.ObjectID  `json:"todo_id" bson:"_todo_id"`
+	UserID   primitive.ObjectID  `json:"user_id" bson:"_user_id"`
+	ParentID *primitive.ObjectID `json:"parent_id" bson:"_parent_id,omitempty"`
+	Body     string              `json:"body" bson:"_body"`
+	EditedAt *time.Time          `json:"edited_at,omitempty" bson:"_edited_at,omitempty"`
+	History  []CommentEdit       `json:"history,omitempty" bson:"_history,omitempty"`
+	// Deleted comments with replies stay as an empty placeholder so the
+	// thread holds together
+	Deleted   bool      `json:"deleted" bson:"_deleted,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// CommentEdit is an earlier body of an edited comment
+type CommentEdit struct {
+	Body     string    `json:"body" bson:"_body"`
+	EditedAt time.Time `json:"edited_at" bson:"_edited_at"`
+}
+
+// CommentPage is one page of the comments of a todo, Next is the cursor of
+// the page after it
+type CommentPage struct {
+	Items []Comment `json:"items"`
+	Next  string    `json:"next,omitempty"`
+}
+
+const (
+	DefaultCommentPageSize = 50
+	MaxCommentPageSize     = 100
+	MaxCommentLength       = 10000
+)
+
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	// ErrNotCommentAuthor is returned when editing or deleting somebody
+	// else's comment
+	ErrNotCommentAuthor = errors.New("comment belongs to another user")
+)
+
+func NewCommentService(mongo *mongo.Client) Comment {
+	client = mongo
+	return Comment{}
+}
+
+func returnCommentsCollection(collection string) *mongo.Collection {
+	return client.Database("todos_db").Collection(collection)
+}
+
+// GetComments returns up to limit comments of a todo in the order they were
+// written, starting after the comment with id after
+func (c *Comment) GetComments(todoID string, after string, limit int) (CommentPage, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return CommentPage{}, ErrTodoNotFound
+	}
+
+	filter := bson.M{"_todo_id": todoOID}
+	if after != "" {
+		afterID, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return CommentPage{}, ErrCommentNotFound
+		}
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	// One more than asked tells whether there is a next page
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit + 1))
+	cursor, err := returnCommentsCollection("comments").Find(context.TODO(), filter, opts)
+	if err != nil {
+		log.Println(err)
+		return CommentPage{}, err
+	}
+
+	page := CommentPage{Items: []Comment{}}
+	if err := cursor.All(context.TODO(), &page.Items); err != nil {
+		log.Println(err)
+		return CommentPage{}, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.Next = page.Items[limit-1].ID
+	}
+	return page, nil
+}
+
+// CountComments counts the comments of a todo, placeholders of deleted
+// comments left out
+func (c *Comment) CountComments(todoID string) (int64, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return 0, err
+	}
+	return returnCommentsCollection("comments").CountDocuments(context.TODO(), bson.M{"_todo_id": todoOID, "_deleted": bson.M{"$ne": true}})
+}
+
+// CountCommentsByTodo counts the comments of several todos at once, keyed by
+// todo id. Todos without comments are left out.
+func (c *Comment) CountCommentsByTodo(todoIDs []string) (map[string]int64, error) {
+	counts := map[string]int64{}
+	ids := objectIDs(todoIDs)
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_todo_id": bson.M{"$in": ids}, "_deleted": bson.M{"$ne": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$_todo_id", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := returnCommentsCollection("comments").Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var groups []struct {
+		TodoID primitive.ObjectID `bson:"_id"`
+		Count  int64              `bson:"count"`
+	}
+	if err := cursor.All(context.TODO(), &groups); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, group := range groups {
+		counts[group.TodoID.Hex()] = group.Count
+	}
+	return counts, nil
+}
+
+// InsertComment adds a comment, a reply has to answer a comment of the same
+// todo
+func (c *Comment) InsertComment(entry Comment) (Comment, error) {
+	collection := returnCommentsCollection("comments")
+
+	if entry.ParentID != nil {
+		count, err := collection.CountDocuments(context.TODO(), bson.M{"_id": *entry.ParentID, "_todo_id": entry.TodoID})
+		if err != nil {
+			log.Println(err)
+			return Comment{}, err
+		}
+		if count == 0 {
+			return Comment{}, ErrCommentNotFound
+		}
+	}
+
+	now := time.Now()
+	comment := Comment{
+		TodoID:    entry.TodoID,
+		UserID:    entry.UserID,
+		ParentID:  entry.ParentID,
+		Body:      entry.Body,
+		CreatedAt: now,
+		UpdatedAt: now,
+
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	res, err := collection.InsertOne(context.TODO(), comment)
+	if err != nil {
+		log.Println("Error: ", err)
+		return Comment{}, err
+	}
+	comment.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return comment, nil
+}
+
+// GetCommentById returns one comment of a todo, the placeholder of a
+// deleted comment included
+func (c *Comment) GetCommentById(todoID string, id string) (Comment, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return Comment{}, ErrCommentNotFound
+	}
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Comment{}, ErrCommentNotFound
+	}
+
+	var comment Comment
+	err = returnCommentsCollection("comments").FindOne(context.TODO(), bson.M{"_id": mongoID, "_todo_id": todoOID}).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		return Comment{}, ErrCommentNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// findOwnComment loads a comment of the todo written by userID
+func findOwnComment(userID primitive.ObjectID, todoID string, id string) (Comment, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return Comment{}, ErrCommentNotFound
+	}
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Comment{}, ErrCommentNotFound
+	}
+
+	var comment Comment
+	err = returnCommentsCollection("comments").FindOne(context.TODO(), bson.M{"_id": mongoID, "_todo_id": todoOID, "_deleted": bson.M{"$ne": true}}).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		return Comment{}, ErrCommentNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Comment{}, err
+	}
+	if comment.UserID != userID {
+		return Comment{}, ErrNotCommentAuthor
+	}
+	return comment, nil
+}
+
+// UpdateComment replaces the body of one of the user's comments, the body
+// it had goes to the history
+func (c *Comment) UpdateComment(userID primitive.ObjectID, todoID string, id string, body string) (Comment, error) {
+	comment, err := findOwnComment(userID, todoID, id)
+	if err != nil {
+		return Comment{}, err
+	}
+	if comment.Body == body {
+		return comment, nil
+	}
+
+	// The history keeps each body with the time it was written
+	edit := CommentEdit{Body: comment.Body, EditedAt: comment.CreatedAt}
+	if comment.EditedAt != nil {
+		edit.EditedAt = *comment.EditedAt
+	}
+	now := time.Now()
+
+	// Matching the old body keeps two concurrent edits from losing one
+	mongoID, _ := primitive.ObjectIDFromHex(comment.ID)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = returnCommentsCollection("comments").FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": mongoID, "_body": comment.Body},
+		bson.M{
+			"$set":  bson.M{"_body": body, "_edited_at": now, "_updated_at": now},
+			"$push": bson.M{"_history": edit},
+		},
+		opts,
+	).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		return Comment{}, ErrCommentNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// DeleteComment removes one of the user's comments. A comment with replies
+// is emptied instead, so the replies keep their place in the thread.
+func (c *Comment) DeleteComment(userID primitive.ObjectID, todoID string, id string) error {
+	comment, err := findOwnComment(userID, todoID, id)
+	if err != nil {
+		return err
+	}
+	collection := returnCommentsCollection("comments")
+	mongoID, _ := primitive.ObjectIDFromHex(comment.ID)
+
+	replies, err := collection.CountDocuments(context.TODO(), bson.M{"_parent_id": mongoID})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if replies == 0 {
+		_, err = collection.DeleteOne(context.TODO(), bson.M{"_id": mongoID})
+	} else {
+		_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": mongoID}, bson.M{
+			"$set":   bson.M{"_deleted": true, "_body": "", "_updated_at": time.Now()},
+			"$unset": bson.M{"_history": ""},
+		})
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
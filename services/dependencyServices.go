@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrDependencyCycle = errors.New("dependency would create a cycle")
+	ErrSelfDependency  = errors.New("todo cannot block itself")
+)
+
+// AddBlocker records that blockerID has to be done before id. Both todos
+// belong to the user, links that would close a cycle are refused.
+func (t *Todo) AddBlocker(userID primitive.ObjectID, id string, blockerID string) error {
+	collection := returnTodosCollection("todos")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+	blockerOID, err := primitive.ObjectIDFromHex(blockerID)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+	if mongoID == blockerOID {
+		return ErrSelfDependency
+	}
+
+	count, err := collection.CountDocuments(context.TODO(), bson.M{"_id": blockerOID, "_user_id": userID})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if count == 0 {
+		return ErrTodoNotFound
+	}
+
+	cycle, err := dependsOn(blockerOID, mongoID)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrDependencyCycle
+	}
+
+	res, err := collection.UpdateOne(
+		context.TODO(),
+		bson.M{"_id": mongoID, "_user_id": userID},
+		bson.M{
+			"$addToSet": bson.M{"_blocked_by": blockerOID},
+			"$set":      bson.M{"_updated_at": time.Now()},
+			"$inc":      incVersion,
+		},
+	)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTodoNotFound
+	}
+	return nil
+}
+
+// dependsOn reports whether id is blocked by target, directly or through
+// other todos
+func dependsOn(id primitive.ObjectID, target primitive.ObjectID) (bool, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"_id": id}},
+		bson.M{"$graphLookup": bson.M{
+			"from":             "todos",
+			"startWith":        "$_blocked_by",
+			"connectFromField": "_blocked_by",
+			"connectToField":   "_id",
+			"as":               "blockers",
+		}},
+		bson.M{"$project": bson.M{"found": bson.M{"$in": bson.A{target, "$blockers._id"}}}},
+	}
+	cursor, err := returnTodosCollection("todos").Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	var rows []struct {
+		Found bool `bson:"found"`
+	}
+	if err := cursor.All(context.TODO(), &rows); err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return len(rows) > 0 && rows[0].Found, nil
+}
+
+// RemoveBlocker removes blockerID from the blockers of the user's todo id
+func (t *Todo) RemoveBlocker(userID primitive.ObjectID, id string, blockerID string) error {
+	collection := returnTodosCollection("todos")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+	blockerOID, err := primitive.ObjectIDFromHex(blockerID)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+
+	res, err := collection.UpdateOne(
+		context.TODO(),
+		bson.M{"_id": mongoID, "_user_id": userID, "_blocked_by": blockerOID},
+		bson.M{
+			"$pull": bson.M{"_blocked_by": blockerOID},
+			"$set":  bson.M{"_updated_at": time.Now()},
+			"$inc":  incVersion,
+		},
+	)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTodoNotFound
+	}
+	return nil
+}
+
+// GetBlockers returns the todos that block todo
+func (t *Todo) GetBlockers(todo Todo) ([]Todo, error) {
+	if len(todo.BlockedBy) == 0 {
+		return []Todo{}, nil
+	}
+	return findTodos(bson.M{"_id": bson.M{"$in": todo.BlockedBy}, "_deleted_at": nil})
+}
+
+// GetBlocking returns the todos that todo blocks
+func (t *Todo) GetBlocking(todo Todo) ([]Todo, error) {
+	return findTodos(bson.M{"_blocked_by": todo.mongoID(), "_deleted_at": nil})
+}
+
+// OpenBlockers returns the blockers of todo that are not done yet
+func (t *Todo) OpenBlockers(todo Todo) ([]Todo, error) {
+	if len(todo.BlockedBy) == 0 {
+		return []Todo{}, nil
+	}
+	return findTodos(bson.M{"_id": bson.M{"$in": todo.BlockedBy}, "_completed": false, "_deleted_at": nil})
+}
+
+// GetDependencies loads the blockers of several todos and the todos they
+// block at once, both keyed by todo id
+func (t *Todo) GetDependencies(todos []Todo) (map[string][]Todo, map[string][]Todo, error) {
+	blockedBy, blocks := map[string][]Todo{}, map[string][]Todo{}
+	if len(todos) == 0 {
+		return blockedBy, blocks, nil
+	}
+
+	var ids, blockerIDs []primitive.ObjectID
+	for _, todo := range todos {
+		ids = append(ids, todo.mongoID())
+		blockerIDs = append(blockerIDs, todo.BlockedBy...)
+	}
+
+	byID := map[primitive.ObjectID]Todo{}
+	if len(blockerIDs) > 0 {
+		blockers, err := findTodos(bson.M{"_id": bson.M{"$in": blockerIDs}, "_deleted_at": nil})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, blocker := range blockers {
+			byID[blocker.mongoID()] = blocker
+		}
+	}
+	for _, todo := range todos {
+		for _, blockerID := range todo.BlockedBy {
+			if blocker, ok := byID[blockerID]; ok {
+				blockedBy[todo.ID] = append(blockedBy[todo.ID], blocker)
+			}
+		}
+	}
+
+	blocking, err := findTodos(bson.M{"_blocked_by": bson.M{"$in": ids}, "_deleted_at": nil})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, blocked := range blocking {
+		for _, blockerID := range blocked.BlockedBy {
+			blocks[blockerID.Hex()] = append(blocks[blockerID.Hex()], blocked)
+		}
+	}
+	return blockedBy, blocks, nil
+}
+
+func findTodos(filter bson.M) ([]Todo, error) {
+	cursor, err := returnTodosCollection("todos").Find(context.TODO(), filter)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	todos := []Todo{}
+	if err := cursor.All(context.TODO(), &todos); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return todos, nil
+}
+
+// OrderByDependencies sorts todos so every todo comes after the todos in
+// the list that block it. Todos that are free at the same time keep the
+// order of their due date, then creation. Blockers outside the list are
+// ignored.
+func OrderByDependencies(todos []Todo) []Todo {
+	sort.SliceStable(todos, func(i, j int) bool {
+		a, b := todos[i], todos[j]
+		if (a.DateDue == nil) != (b.DateDue == nil) {
+			return a.DateDue != nil
+		}
+		if a.DateDue != nil && !a.DateDue.Equal(*b.DateDue) {
+			return a.DateDue.Before(*b.DateDue)
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+
+	index := map[string]int{}
+	for i, todo := range todos {
+		index[todo.ID] = i
+	}
+	waiting := make([]int, len(todos))
+	blocks := make([][]int, len(todos))
+	for i, todo := range todos {
+		for _, blocker := range todo.BlockedBy {
+			if j, ok := index[blocker.Hex()]; ok {
+				waiting[i]++
+				blocks[j] = append(blocks[j], i)
+			}
+		}
+	}
+
+	// Kahn's algorithm, always taking the earliest free todo
+	ordered := make([]Todo, 0, len(todos))
+	done := make([]bool, len(todos))
+	for len(ordered) < len(todos) {
+		next := -1
+		for i := range todos {
+			if !done[i] && waiting[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			// Only reachable when two links added at the same time
+			// closed a cycle, the rest keeps its sorted order
+			for i := range todos {
+				if !done[i] {
+					ordered = append(ordered, todos[i])
+				}
+			}
+			break
+		}
+		done[next] = true
+		ordered = append(ordered, todos[next])
+		for _, i := range blocks[next] {
+			waiting[i]--
+		}
+	}
+	return ordered
+}
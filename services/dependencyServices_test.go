@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOrderByDependencies(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) *time.Time {
+		d := start.AddDate(0, 0, n)
+		return &d
+	}
+	ids := map[string]primitive.ObjectID{}
+	todo := func(name string, due *time.Time, created int, blockers ...string) Todo {
+		if _, ok := ids[name]; !ok {
+			ids[name] = primitive.NewObjectID()
+		}
+		t := Todo{ID: ids[name].Hex(), Task: name, DateDue: due, CreatedAt: start.Add(time.Duration(created) * time.Minute)}
+		for _, blocker := range blockers {
+			if _, ok := ids[blocker]; !ok {
+				ids[blocker] = primitive.NewObjectID()
+			}
+			t.BlockedBy = append(t.BlockedBy, ids[blocker])
+		}
+		return t
+	}
+
+	tests := []struct {
+		name  string
+		todos []Todo
+		want  []string
+	}{
+		{
+			name:  "due date, then no due date, then creation",
+			todos: []Todo{todo("c", nil, 1), todo("b", day(2), 2), todo("a", day(1), 3), todo("d", nil, 0)},
+			want:  []string{"a", "b", "d", "c"},
+		},
+		{
+			name:  "blocker comes first",
+			todos: []Todo{todo("first", day(1), 0, "second"), todo("second", day(5), 1)},
+			want:  []string{"second", "first"},
+		},
+		{
+			name: "chain",
+			todos: []Todo{
+				todo("x", day(1), 0, "y"),
+				todo("y", day(2), 1, "z"),
+				todo("z", day(3), 2),
+				todo("free", day(4), 3),
+			},
+			want: []string{"z", "y", "x", "free"},
+		},
+		{
+			name:  "blocker outside the list is ignored",
+			todos: []Todo{todo("late", day(2), 0), todo("early", day(1), 1, "elsewhere")},
+			want:  []string{"early", "late"},
+		},
+		{
+			name:  "cycle keeps the sorted order",
+			todos: []Todo{todo("p", day(2), 0, "q"), todo("q", day(1), 1, "p"), todo("r", day(3), 2)},
+			want:  []string{"r", "q", "p"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ordered := OrderByDependencies(tt.todos)
+			if len(ordered) != len(tt.want) {
+				t.Fatalf("got %d todos, want %d", len(ordered), len(tt.want))
+			}
+			for i, todo := range ordered {
+				if todo.Task != tt.want[i] {
+					var got []string
+					for _, todo := range ordered {
+						got = append(got, todo.Task)
+					}
+					t.Fatalf("order = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
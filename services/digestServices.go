@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Digest summarises a user's todos for one day, or one week for weekly
+// digests: what is due in the period, what is overdue and what was
+// completed in the period before.
+type Digest struct {
+	User        User          `json:"-"`
+	Frequency   string        `json:"frequency"`
+	PeriodStart time.Time     `json:"period_start"`
+	PeriodEnd   time.Time     `json:"period_end"`
+	Due         []DigestEntry `json:"due"`
+	Overdue     []DigestEntry `json:"overdue"`
+	Completed   []DigestEntry `json:"completed"`
+}
+
+// DigestEntry is one todo in a digest with its details
+type DigestEntry struct {
+	ID          string     `json:"id"`
+	Task        string     `json:"task"`
+	DateDue     *time.Time `json:"date_due,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Priority    string     `json:"priority,omitempty"`
+	Status      string     `json:"status,omitempty"`
+}
+
+// DigestRun marks a digest as sent, the unique index on user, frequency and
+// period lets only one instance send it
+type DigestRun struct {
+	UserID    primitive.ObjectID `bson:"_user_id"`
+	Frequency string             `bson:"_frequency"`
+	Period    string             `bson:"_period"`
+	CreatedAt time.Time          `bson:"_created_at"`
+}
+
+// Empty reports whether there is nothing to tell the user
+func (d Digest) Empty() bool {
+	return len(d.Due) == 0 && len(d.Overdue) == 0 && len(d.Completed) == 0
+}
+
+func NewDigestService(mongo *mongo.Client) Digest {
+	client = mongo
+	return Digest{}
+}
+
+// digestDays is the length of the period a digest covers
+func digestDays(frequency string) int {
+	if frequency == DigestWeekly {
+		return 7
+	}
+	return 1
+}
+
+// DigestPeriod returns the local day, or week for weekly digests, that
+// starts at the beginning of the user's day at now
+func DigestPeriod(user User, frequency string, now time.Time) (time.Time, time.Time) {
+	local := now.In(user.Location())
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	return start, start.AddDate(0, 0, digestDays(frequency))
+}
+
+// BuildDigest collects the digest of the user for the period at now
+func (d *Digest) BuildDigest(ctx context.Context, user User, frequency string, now time.Time) (Digest, error) {
+	userID, err := primitive.ObjectIDFromHex(user.ID)
+	if err != nil {
+		return Digest{}, ErrUserNotFound
+	}
+
+	start, end := DigestPeriod(user, frequency, now)
+	previous := start.AddDate(0, 0, -digestDays(frequency))
+	digest := Digest{
+		User:        user,
+		Frequency:   frequency,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Due:         []DigestEntry{},
+		Overdue:     []DigestEntry{},
+		Completed:   []DigestEntry{},
+	}
+
+	filter := bson.M{
+		"_user_id":    userID,
+		"_deleted_at": nil,
+		"$or": bson.A{
+			bson.M{"_completed": false, "_date_due": bson.M{"$lt": end}},
+			bson.M{"_completed": true, "_completed_at": bson.M{"$gte": previous, "$lt": start}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_date_due", Value: 1}})
+	cursor, err := returnTodosCollection("todos").Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return Digest{}, err
+	}
+	var todos []Todo
+	if err := cursor.All(ctx, &todos); err != nil {
+		log.Println(err)
+		return Digest{}, err
+	}
+
+	details, err := digestDetails(ctx, todos)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	for _, todo := range todos {
+		entry := DigestEntry{
+			ID:          todo.ID,
+			Task:        todo.Task,
+			DateDue:     todo.DateDue,
+			CompletedAt: todo.CompletedAt,
+		}
+		if detail, ok := details[todo.ID]; ok {
+			entry.Priority = detail.PriorityDetails
+			entry.Status = detail.StatusDetails
+		}
+
+		switch {
+		case todo.Completed:
+			digest.Completed = append(digest.Completed, entry)
+		case todo.DateDue.Before(start):
+			digest.Overdue = append(digest.Overdue, entry)
+		default:
+			digest.Due = append(digest.Due, entry)
+		}
+	}
+	return digest, nil
+}
+
+// digestDetails loads the details of the todos keyed by todo id
+func digestDetails(ctx context.Context, todos []Todo) (map[string]TodoDetails, error) {
+	ids := bson.A{}
+	for _, todo := range todos {
+		ids = append(ids, todo.mongoID())
+	}
+
+	result := map[string]TodoDetails{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	cursor, err := returnTodoDetailsCollection("todo_details").Find(ctx, bson.M{"_todo_id": bson.M{"$in": ids}, "_deleted_at": nil})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var details []TodoDetails
+	if err := cursor.All(ctx, &details); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, detail := range details {
+		result[detail.TodoID.Hex()] = detail
+	}
+	return result, nil
+}
+
+// ClaimDigestRun records that the digest for the period is being sent. It
+// returns false when it was sent already, by this or another instance.
+func (d *Digest) ClaimDigestRun(ctx context.Context, userID primitive.ObjectID, frequency string, period string) (bool, error) {
+	_, err := returnTodosCollection("digest_runs").InsertOne(ctx, DigestRun{
+		UserID:    userID,
+		Frequency: frequency,
+		Period:    period,
+		CreatedAt: time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseDigestRun forgets a claimed run so the digest is tried again, used
+// when sending it failed
+func (d *Digest) ReleaseDigestRun(ctx context.Context, userID primitive.ObjectID, frequency string, period string) error {
+	_, err := returnTodosCollection("digest_runs").DeleteOne(ctx, bson.M{
+		"_user_id":   userID,
+		"_frequency": frequency,
+		"_period":    period,
+	})
+	return err
+}
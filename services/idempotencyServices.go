@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IdempotencyKey is a request made with an Idempotency-Key header and,
+// once handled, the response it got. Retrying the request with the same
+// key replays the response instead of doing the work again.
+type IdempotencyKey struct {
+	ID     string             `json:"-" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"-" bson:"_user_id"`
+	Key    string             `json:"-" bson:"_key"`
+	// Fingerprint identifies the request: method, path and body
+	Fingerprint string `json:"-" bson:"_fingerprint,omitempty"`
+	// Done is set once the response is stored, until then the request
+	// holds the key until LeaseUntil
+	Done       bool                `json:"-" bson:"_done"`
+	LeaseUntil time.Time           `json:"-" bson:"_lease_until"`
+	Status     int                 `json:"-" bson:"_status,omitempty"`
+	Header     map[string][]string `json:"-" bson:"_header,omitempty"`
+	Body       []byte              `json:"-" bson:"_body,omitempty"`
+	CreatedAt  time.Time           `json:"-" bson:"_created_at"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// IdempotencyKeyTTL is how long a key is remembered, the TTL index on
+// _created_at removes it afterwards
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyLease is how long a request may take before its key can be
+// claimed by a retry, the process handling it may have died
+const IdempotencyLease = 2 * time.Minute
+
+// MaxIdempotencyKeyLength is the longest key accepted
+const MaxIdempotencyKeyLength = 255
+
+var (
+	// ErrIdempotencyKeyInProgress is returned while the first request made
+	// with the key is still being handled
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is in progress")
+	// ErrIdempotencyKeyReused is returned when the key comes with another
+	// request than it was first used for
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used for another request")
+)
+
+func returnIdempotencyKeysCollection(collection string) *mongo.Collection {
+	return client.Database("todos_db").Collection(collection)
+}
+
+// ClaimIdempotencyKey claims key for a new request of the user. When the
+// key is already taken, the request made with it is returned instead, a
+// request that is still running fails with ErrIdempotencyKeyInProgress.
+// A request whose lease ran out without a response is taken over.
+func (k *IdempotencyKey) ClaimIdempotencyKey(userID primitive.ObjectID, key string) (*IdempotencyKey, error) {
+	collection := returnIdempotencyKeysCollection("idempotency_keys")
+	now := time.Now()
+	_, err := collection.InsertOne(context.TODO(), IdempotencyKey{
+		UserID:     userID,
+		Key:        key,
+		LeaseUntil: now.Add(IdempotencyLease),
+		CreatedAt:  now,
+
+		SchemaVersion: CurrentSchemaVersion,
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		log.Println(err)
+		return nil, err
+	}
+
+	stale, err := collection.UpdateOne(context.TODO(),
+		bson.M{"_user_id": userID, "_key": key, "_done": false, "_lease_until": bson.M{"$not": bson.M{"$gte": now}}},
+		bson.M{"$set": bson.M{"_lease_until": now.Add(IdempotencyLease), "_created_at": now}},
+	)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if stale.ModifiedCount > 0 {
+		return nil, nil
+	}
+
+	var existing IdempotencyKey
+	err = collection.FindOne(context.TODO(), bson.M{"_user_id": userID, "_key": key}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		// Expired between the insert and the lookup, the retry claims it
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if !existing.Done {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return &existing, nil
+}
+
+// MatchRequest checks that a retry with the key is the request the key was
+// first used for
+func (k *IdempotencyKey) MatchRequest(fingerprint string) error {
+	if k.Fingerprint != fingerprint {
+		return ErrIdempotencyKeyReused
+	}
+	return nil
+}
+
+// SaveIdempotentResponse stores the response of the request the user
+// claimed key for
+func (k *IdempotencyKey) SaveIdempotentResponse(userID primitive.ObjectID, key string, response IdempotencyKey) error {
+	_, err := returnIdempotencyKeysCollection("idempotency_keys").UpdateOne(context.TODO(),
+		bson.M{"_user_id": userID, "_key": key},
+		bson.M{"$set": bson.M{
+			"_fingerprint": response.Fingerprint,
+			"_done":        true,
+			"_status":      response.Status,
+			"_header":      response.Header,
+			"_body":        response.Body,
+		}},
+	)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// ReleaseIdempotencyKey forgets key, so the request can be tried again
+// with it. Used when the request failed on the server's side.
+func (k *IdempotencyKey) ReleaseIdempotencyKey(userID primitive.ObjectID, key string) error {
+	_, err := returnIdempotencyKeysCollection("idempotency_keys").DeleteOne(context.TODO(), bson.M{"_user_id": userID, "_key": key})
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type indexSpec struct {
+	Collection string
+	Model      mongo.IndexModel
+}
+
+var indexes = []indexSpec{
+	// One details document per todo.
+	{Collection: "todo_details", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_todo_id", Value: 1}},
+		Options: options.Index().SetName("todo_details_todo_id_unique").SetUnique(true),
+	}},
+	{Collection: "todos", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_tag_ids", Value: 1}},
+		Options: options.Index().SetName("todos_user_id_tag_ids"),
+	}},
+	{Collection: "todos", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_project_id", Value: 1}},
+		Options: options.Index().SetName("todos_user_id_project_id"),
+	}},
+	{Collection: "todos", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_blocked_by", Value: 1}},
+		Options: options.Index().SetName("todos_blocked_by"),
+	}},
+	{Collection: "todos", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_deleted_at", Value: 1}},
+		Options: options.Index().SetName("todos_user_id_deleted_at"),
+	}},
+	{Collection: "todos", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_archived_at", Value: 1}},
+		Options: options.Index().SetName("todos_user_id_archived_at"),
+	}},
+	{Collection: "todos", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_project_id", Value: 1}, {Key: "_rank", Value: 1}},
+		Options: options.Index().SetName("todos_user_id_project_id_rank"),
+	}},
+	{Collection: "todos", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_completed", Value: 1}, {Key: "_completed_at", Value: 1}},
+		Options: options.Index().SetName("todos_completed_at"),
+	}},
+	{Collection: "comments", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_todo_id", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("comments_todo_id"),
+	}},
+	{Collection: "attachments", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_todo_id", Value: 1}},
+		Options: options.Index().SetName("attachments_todo_id"),
+	}},
+	{Collection: "activities", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_todo_id", Value: 1}, {Key: "_id", Value: -1}},
+		Options: options.Index().SetName("activities_todo_id"),
+	}},
+	{Collection: "projects", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_position", Value: 1}},
+		Options: options.Index().SetName("projects_user_id_position"),
+	}},
+	{Collection: "subtasks", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_todo_id", Value: 1}, {Key: "_rank", Value: 1}, {Key: "_position", Value: 1}},
+		Options: options.Index().SetName("subtasks_todo_id_rank"),
+	}},
+	// Tag names are unique per user, ignoring case.
+	{Collection: "tags", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_name_key", Value: 1}},
+		Options: options.Index().SetName("tags_user_id_name_key_unique").SetUnique(true),
+	}},
+	// A digest is sent once per user and period.
+	{Collection: "digest_runs", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_frequency", Value: 1}, {Key: "_period", Value: 1}},
+		Options: options.Index().SetName("digest_runs_user_period_unique").SetUnique(true),
+	}},
+	{Collection: "reminders", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_todo_id", Value: 1}},
+		Options: options.Index().SetName("reminders_todo_id"),
+	}},
+	// The scheduler looks up unsent reminders by fire time.
+	{Collection: "reminders", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_sent_at", Value: 1}, {Key: "_failed_at", Value: 1}, {Key: "_fire_at", Value: 1}},
+		Options: options.Index().SetName("reminders_due"),
+	}},
+	// One record per idempotency key of a user, forgotten after a day.
+	{Collection: "idempotency_keys", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_user_id", Value: 1}, {Key: "_key", Value: 1}},
+		Options: options.Index().SetName("idempotency_keys_user_id_key_unique").SetUnique(true),
+	}},
+	{Collection: "idempotency_keys", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "_created_at", Value: 1}},
+		Options: options.Index().SetName("idempotency_keys_created_at_ttl").SetExpireAfterSeconds(int32(IdempotencyKeyTTL.Seconds())),
+	}},
+}
+
+// EnsureIndexes creates the indexes the services rely on. Creating an index
+// that already exists is a no-op, so this runs on every start. A failure is
+// logged and returned but never leaves the other indexes uncreated.
+func EnsureIndexes(ctx context.Context) error {
+	var firstErr error
+	for _, spec := range indexes {
+		name, err := client.Database("todos_db").Collection(spec.Collection).Indexes().CreateOne(ctx, spec.Model)
+		if err != nil {
+			log.Printf("Could not create index on %s: %v\n", spec.Collection, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Printf("Index %s on %s is ready\n", name, spec.Collection)
+	}
+	return firstErr
+}
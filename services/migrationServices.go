@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CurrentSchemaVersion is stamped on every document we insert. Bump it
+// together with a new entry in Migrations whenever the stored shape changes.
+const CurrentSchemaVersion = 4
+
+// legacyFieldNames maps field names written by older versions of the service
+// to their canonical name. Todo and User used to store "_update_at" while
+// TodoDetails used "_updated_at".
+var legacyFieldNames = map[string]string{
+	"_update_at": "_updated_at",
+}
+
+// referenceFields point at another document's _id. Before v3 they were
+// stored as hex strings, and todos created without an owner stored "".
+var referenceFields = []string{"_user_id", "_todo_id"}
+
+// Migration rewrites every document of a collection that is below Version.
+// Upgrade returns the update applied to a single document; the migration
+// runner adds the "_schema_version" bump itself.
+type Migration struct {
+	Version    int
+	Name       string
+	Collection string
+	Upgrade    func(doc bson.Raw) (bson.D, error)
+}
+
+type MigrationResult struct {
+	Migration string `json:"migration"`
+	Scanned   int    `json:"scanned"`
+	Modified  int    `json:"modified"`
+	Failed    int    `json:"failed"`
+}
+
+var Migrations = []Migration{
+	{Version: 2, Name: "v2 canonical field names", Collection: "todos", Upgrade: renameLegacyFields},
+	{Version: 2, Name: "v2 canonical field names", Collection: "todo_details", Upgrade: renameLegacyFields},
+	{Version: 2, Name: "v2 canonical field names", Collection: "users", Upgrade: renameLegacyFields},
+	{Version: 3, Name: "v3 object id references", Collection: "todos", Upgrade: convertReferences},
+	{Version: 3, Name: "v3 object id references", Collection: "todo_details", Upgrade: convertReferences},
+	{Version: 4, Name: "v4 status and priority values", Collection: "todo_details", Upgrade: normalizeDetailsEnums},
+}
+
+// RunMigrations applies every pending migration in order. Documents are
+// paged by _id in batches of batchSize so a failing document never stalls
+// the run and a restarted run picks up where the previous one stopped.
+func RunMigrations(ctx context.Context, batchSize int, dryRun bool) ([]MigrationResult, error) {
+	var results []MigrationResult
+	for _, m := range Migrations {
+		res, err := runMigration(ctx, m, batchSize, dryRun)
+		results = append(results, res)
+		if err != nil {
+			return results, err
+		}
+		log.Printf("migration %q on %s: scanned=%d modified=%d failed=%d\n", m.Name, m.Collection, res.Scanned, res.Modified, res.Failed)
+	}
+	return results, nil
+}
+
+func runMigration(ctx context.Context, m Migration, batchSize int, dryRun bool) (MigrationResult, error) {
+	collection := client.Database("todos_db").Collection(m.Collection)
+	res := MigrationResult{Migration: fmt.Sprintf("%s (%s)", m.Name, m.Collection)}
+
+	pending := bson.M{"$or": bson.A{
+		bson.M{"_schema_version": bson.M{"$exists": false}},
+		bson.M{"_schema_version": bson.M{"$lt": m.Version}},
+	}}
+
+	var lastID interface{}
+	for {
+		filter := pending
+		if lastID != nil {
+			filter = bson.M{"$and": bson.A{pending, bson.M{"_id": bson.M{"$gt": lastID}}}}
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(batchSize))
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			return res, err
+		}
+
+		var docs []bson.Raw
+		if err := cursor.All(ctx, &docs); err != nil {
+			return res, err
+		}
+		if len(docs) == 0 {
+			return res, nil
+		}
+
+		var models []mongo.WriteModel
+		for _, doc := range docs {
+			id := doc.Lookup("_id")
+			lastID = id
+			res.Scanned++
+
+			update, err := m.Upgrade(doc)
+			if err != nil {
+				log.Printf("migration %q: skipping %v: %v\n", m.Name, id, err)
+				res.Failed++
+				continue
+			}
+			update = appendSet(update, bson.E{Key: "_schema_version", Value: m.Version})
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": id}).
+				SetUpdate(update))
+		}
+
+		if dryRun || len(models) == 0 {
+			res.Modified += len(models)
+			continue
+		}
+
+		bulk, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if bulk != nil {
+			res.Modified += int(bulk.ModifiedCount)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+}
+
+// appendSet adds field to the $set stage of update, creating it if needed.
+func appendSet(update bson.D, field bson.E) bson.D {
+	for i, op := range update {
+		if op.Key == "$set" {
+			set, _ := op.Value.(bson.D)
+			update[i].Value = append(set, field)
+			return update
+		}
+	}
+	return append(update, bson.E{Key: "$set", Value: bson.D{field}})
+}
+
+func renameLegacyFields(doc bson.Raw) (bson.D, error) {
+	rename := bson.D{}
+	unset := bson.D{}
+	for legacy, canonical := range legacyFieldNames {
+		if _, err := doc.LookupErr(legacy); err != nil {
+			continue
+		}
+		// A document touched by both versions keeps the canonical value.
+		if _, err := doc.LookupErr(canonical); err == nil {
+			unset = append(unset, bson.E{Key: legacy, Value: ""})
+			continue
+		}
+		rename = append(rename, bson.E{Key: legacy, Value: canonical})
+	}
+
+	update := bson.D{}
+	if len(rename) > 0 {
+		update = append(update, bson.E{Key: "$rename", Value: rename})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return update, nil
+}
+
+func convertReferences(doc bson.Raw) (bson.D, error) {
+	set := bson.D{}
+	unset := bson.D{}
+	for _, field := range referenceFields {
+		value, err := doc.LookupErr(field)
+		if err != nil || value.Type != bsontype.String {
+			continue
+		}
+		str := value.StringValue()
+		if str == "" {
+			unset = append(unset, bson.E{Key: field, Value: ""})
+			continue
+		}
+		oid, err := primitive.ObjectIDFromHex(str)
+		if err != nil {
+			// Leave the document alone, it is counted as failed and
+			// retried once the value is fixed by hand.
+			return nil, fmt.Errorf("%s %q is not an object id", field, str)
+		}
+		set = append(set, bson.E{Key: field, Value: oid})
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return update, nil
+}
+
+// isLegacyReference reports whether elem is a reference still stored as a
+// string.
+func isLegacyReference(elem bson.RawElement) bool {
+	for _, field := range referenceFields {
+		if elem.Key() == field {
+			return elem.Value().Type == bsontype.String
+		}
+	}
+	return false
+}
+
+// upgradeLegacyDocument rewrites a stored document into the current shape
+// before it is decoded, so reads keep working while RunMigrations has not
+// reached every document yet.
+func upgradeLegacyDocument(data []byte) ([]byte, error) {
+	raw := bson.Raw(data)
+	elements, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	legacy := false
+	for _, elem := range elements {
+		if _, ok := legacyFieldNames[elem.Key()]; ok || isLegacyReference(elem) {
+			legacy = true
+			break
+		}
+	}
+	if !legacy {
+		return data, nil
+	}
+
+	doc := bson.D{}
+	for _, elem := range elements {
+		key := elem.Key()
+		if canonical, ok := legacyFieldNames[key]; ok {
+			if _, err := raw.LookupErr(canonical); err == nil {
+				continue
+			}
+			key = canonical
+		}
+		if isLegacyReference(elem) {
+			// Unreadable references are dropped rather than failing the
+			// whole decode; the document then shows up as an orphan.
+			oid, err := primitive.ObjectIDFromHex(elem.Value().StringValue())
+			if err != nil {
+				continue
+			}
+			doc = append(doc, bson.E{Key: key, Value: oid})
+			continue
+		}
+		doc = append(doc, bson.E{Key: key, Value: elem.Value()})
+	}
+	return bson.Marshal(doc)
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrNeighborNotFound is returned when a neighbor given to a move is not
+	// in the same list as the moved item
+	ErrNeighborNotFound = errors.New("neighbor not found in the list")
+	ErrNoNeighbor       = errors.New("a move needs a neighbor")
+)
+
+var rankSort = bson.D{{Key: "_rank", Value: 1}, {Key: "_created_at", Value: 1}}
+
+// needsRebalance matches items whose rank is missing or grew too long
+var needsRebalance = bson.M{"$or": bson.A{
+	bson.M{"_rank": bson.M{"$exists": false}},
+	bson.M{"$expr": bson.M{"$gt": bson.A{bson.M{"$strLenBytes": bson.M{"$ifNull": bson.A{"$_rank", ""}}}, MaxRankLength}}},
+}}
+
+// todoListFilter matches the todos of the user's project, or the inbox when
+// projectID is nil
+func todoListFilter(userID primitive.ObjectID, projectID *primitive.ObjectID) bson.M {
+	filter := bson.M{"_user_id": userID, "_deleted_at": nil}
+	if projectID != nil {
+		filter["_project_id"] = *projectID
+	} else {
+		filter["_project_id"] = bson.M{"$exists": false}
+	}
+	return filter
+}
+
+// lastRank returns a rank after every item of collection matching filter
+func lastRank(collection *mongo.Collection, filter bson.M) string {
+	var last struct {
+		Rank string `bson:"_rank"`
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "_rank", Value: -1}}).SetProjection(bson.M{"_rank": 1})
+	err := collection.FindOne(context.TODO(), filter, opts).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+	}
+	rank, _ := RankBetween(last.Rank, "")
+	return rank
+}
+
+// neighborRanks returns the ranks around the place the moved item goes to.
+// afterID is the item it goes after, beforeID the one it goes before, one of
+// them is enough. ok is false when a neighbor has no rank yet.
+func neighborRanks(collection *mongo.Collection, list bson.M, movedID primitive.ObjectID, afterID, beforeID string) (string, string, bool, error) {
+	find := func(id string) (string, error) {
+		mongoID, err := primitive.ObjectIDFromHex(id)
+		if err != nil || mongoID == movedID {
+			return "", ErrNeighborNotFound
+		}
+		filter := bson.M{"_id": mongoID}
+		for key, value := range list {
+			filter[key] = value
+		}
+		var doc struct {
+			Rank *string `bson:"_rank"`
+		}
+		if err := collection.FindOne(context.TODO(), filter).Decode(&doc); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return "", ErrNeighborNotFound
+			}
+			log.Println(err)
+			return "", err
+		}
+		if doc.Rank == nil {
+			return "", nil
+		}
+		return *doc.Rank, nil
+	}
+	// adjacent finds the rank next to rank, skipping the moved item
+	adjacent := func(rank string, next bool) (string, error) {
+		filter := bson.M{"_id": bson.M{"$ne": movedID}}
+		for key, value := range list {
+			filter[key] = value
+		}
+		sort := bson.D{{Key: "_rank", Value: -1}}
+		filter["_rank"] = bson.M{"$lt": rank}
+		if next {
+			sort = bson.D{{Key: "_rank", Value: 1}}
+			filter["_rank"] = bson.M{"$gt": rank}
+		}
+		var doc struct {
+			Rank string `bson:"_rank"`
+		}
+		err := collection.FindOne(context.TODO(), filter, options.FindOne().SetSort(sort)).Decode(&doc)
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Println(err)
+			return "", err
+		}
+		return doc.Rank, nil
+	}
+
+	if afterID == "" && beforeID == "" {
+		return "", "", false, ErrNoNeighbor
+	}
+	var after, before string
+	var err error
+	if afterID != "" {
+		if after, err = find(afterID); err != nil || after == "" {
+			return "", "", false, err
+		}
+	}
+	if beforeID != "" {
+		if before, err = find(beforeID); err != nil || before == "" {
+			return "", "", false, err
+		}
+	}
+	if afterID == "" {
+		after, err = adjacent(before, false)
+	} else if beforeID == "" {
+		before, err = adjacent(after, true)
+	}
+	return after, before, err == nil, err
+}
+
+// rebalance gives the items of collection matching filter evenly spread
+// ranks, keeping their order
+func rebalance(ctx context.Context, collection *mongo.Collection, filter bson.M, sort bson.D) error {
+	opts := options.Find().SetSort(sort).SetProjection(bson.M{"_id": 1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	ranks := SpreadRanks(len(docs))
+	models := make([]mongo.WriteModel, len(docs))
+	for i, doc := range docs {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"_rank": ranks[i]}})
+	}
+	_, err = collection.BulkWrite(ctx, models)
+	return err
+}
+
+// move gives the item mongoID a rank between its new neighbors. A list
+// whose ranks leave no room, or that has items without a rank, is
+// rebalanced first.
+func move(collection *mongo.Collection, list bson.M, sort bson.D, mongoID primitive.ObjectID, afterID, beforeID string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		after, before, ok, err := neighborRanks(collection, list, mongoID, afterID, beforeID)
+		if err != nil {
+			return "", err
+		}
+		rank := ""
+		if ok {
+			rank, err = RankBetween(after, before)
+			if err != nil && !errors.Is(err, ErrRankCollision) {
+				return "", err
+			}
+		}
+		if rank == "" {
+			if attempt > 0 {
+				return "", ErrRankCollision
+			}
+			if err := rebalance(context.TODO(), collection, list, sort); err != nil {
+				log.Println(err)
+				return "", err
+			}
+			continue
+		}
+
+		_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": mongoID}, bson.M{"$set": bson.M{"_rank": rank}})
+		if err != nil {
+			log.Println(err)
+			return "", err
+		}
+		return rank, nil
+	}
+}
+
+// MoveTodo places one of the user's todos between two todos of its list
+func (t *Todo) MoveTodo(userID primitive.ObjectID, id string, afterID string, beforeID string) (Todo, error) {
+	collection := returnTodosCollection("todos")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Todo{}, ErrTodoNotFound
+	}
+
+	var todo Todo
+	err = collection.FindOne(context.TODO(), bson.M{"_id": mongoID, "_user_id": userID, "_deleted_at": nil}).Decode(&todo)
+	if err != nil {
+		return Todo{}, ErrTodoNotFound
+	}
+
+	todo.Rank, err = move(collection, todoListFilter(userID, todo.ProjectID), rankSort, mongoID, afterID, beforeID)
+	if err != nil {
+		return Todo{}, err
+	}
+	return todo, nil
+}
+
+// MoveSubtask places a subtask between two other subtasks of its todo
+func (s *Subtask) MoveSubtask(todoID string, id string, afterID string, beforeID string) (Subtask, error) {
+	collection := returnSubtasksCollection("subtasks")
+	filter, err := subtaskFilter(todoID, id)
+	if err != nil {
+		return Subtask{}, err
+	}
+
+	var subtask Subtask
+	if err := collection.FindOne(context.TODO(), filter).Decode(&subtask); err != nil {
+		return Subtask{}, ErrSubtaskNotFound
+	}
+
+	list := bson.M{"_todo_id": subtask.TodoID}
+	subtask.Rank, err = move(collection, list, subtaskSort, filter["_id"].(primitive.ObjectID), afterID, beforeID)
+	if err != nil {
+		return Subtask{}, err
+	}
+	return subtask, nil
+}
+
+// RebalanceTodoLists rebalances every todo list with ranks that are missing
+// or too long and returns how many lists it rebalanced
+func (t *Todo) RebalanceTodoLists(ctx context.Context) (int, error) {
+	collection := returnTodosCollection("todos")
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"_deleted_at": nil, "_user_id": bson.M{"$exists": true}}},
+		bson.M{"$match": needsRebalance},
+		bson.M{"$group": bson.M{"_id": bson.M{"user": "$_user_id", "project": "$_project_id"}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var lists []struct {
+		ID struct {
+			User    primitive.ObjectID  `bson:"user"`
+			Project *primitive.ObjectID `bson:"project"`
+		} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &lists); err != nil {
+		return 0, err
+	}
+
+	for i, list := range lists {
+		if err := rebalance(ctx, collection, todoListFilter(list.ID.User, list.ID.Project), rankSort); err != nil {
+			return i, err
+		}
+	}
+	return len(lists), nil
+}
+
+// RebalanceSubtaskLists does what RebalanceTodoLists does for the subtasks
+// of every todo
+func (s *Subtask) RebalanceSubtaskLists(ctx context.Context) (int, error) {
+	collection := returnSubtasksCollection("subtasks")
+	pipeline := bson.A{
+		bson.M{"$match": needsRebalance},
+		bson.M{"$group": bson.M{"_id": "$_todo_id"}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var todos []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &todos); err != nil {
+		return 0, err
+	}
+
+	for i, todo := range todos {
+		if err := rebalance(ctx, collection, bson.M{"_todo_id": todo.ID}, subtaskSort); err != nil {
+			return i, err
+		}
+	}
+	return len(todos), nil
+}
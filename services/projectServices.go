@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Project struct {
+	ID        string             `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"_user_id"`
+	Name      string             `json:"name" bson:"_name"`
+	Color     string             `json:"color" bson:"_color"`
+	Icon      string             `json:"icon" bson:"_icon"`
+	Archived  bool               `json:"archived" bson:"_archived"`
+	Position  int                `json:"position" bson:"_position"`
+	CreatedAt time.Time          `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// What happens to the todos of a deleted project
+const (
+	ProjectTodosMove   = "move"
+	ProjectTodosDelete = "delete"
+)
+
+type DeleteProjectResult struct {
+	ProjectID    string `json:"project_id"`
+	TodosMoved   int64  `json:"todos_moved"`
+	TodosDeleted int64  `json:"todos_deleted"`
+}
+
+var (
+	ErrProjectNotFound = errors.New("project not found")
+	ErrProjectNotEmpty = errors.New("project still has todos")
+	// ErrProjectMoveIntoSelf is returned when the todos of a deleted
+	// project would be moved into that same project
+	ErrProjectMoveIntoSelf = errors.New("cannot move todos into the project being deleted")
+)
+
+func NewProjectService(mongo *mongo.Client) Project {
+	client = mongo
+	return Project{}
+}
+
+func returnProjectsCollection(collection string) *mongo.Collection {
+	return client.Database("todos_db").Collection(collection)
+}
+
+// GetProjectsByUser returns the user's projects in their manual order
+func (p *Project) GetProjectsByUser(userID primitive.ObjectID, includeArchived bool) ([]Project, error) {
+	collection := returnProjectsCollection("projects")
+	filter := bson.M{"_user_id": userID}
+	if !includeArchived {
+		filter["_archived"] = false
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_position", Value: 1}, {Key: "_created_at", Value: 1}})
+
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	projects := []Project{}
+	if err := cursor.All(context.TODO(), &projects); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return projects, nil
+}
+
+// GetProjectById returns one of the user's projects
+func (p *Project) GetProjectById(userID primitive.ObjectID, id string) (Project, error) {
+	collection := returnProjectsCollection("projects")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Project{}, ErrProjectNotFound
+	}
+
+	var project Project
+	err = collection.FindOne(context.TODO(), bson.M{"_id": mongoID, "_user_id": userID}).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		return Project{}, ErrProjectNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Project{}, err
+	}
+	return project, nil
+}
+
+// InsertProject adds the project after the user's other projects and
+// returns it as stored
+func (p *Project) InsertProject(entry Project) (Project, error) {
+	collection := returnProjectsCollection("projects")
+
+	count, err := collection.CountDocuments(context.TODO(), bson.M{"_user_id": entry.UserID})
+	if err != nil {
+		log.Println(err)
+		return Project{}, err
+	}
+
+	project := Project{
+		UserID:    entry.UserID,
+		Name:      entry.Name,
+		Color:     entry.Color,
+		Icon:      entry.Icon,
+		Position:  int(count),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	res, err := collection.InsertOne(context.TODO(), project)
+	if err != nil {
+		log.Println("Error: ", err)
+		return Project{}, err
+	}
+	project.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return project, nil
+}
+
+// UpdateProject changes name, color, icon and the archived flag
+func (p *Project) UpdateProject(userID primitive.ObjectID, id string, entry Project) error {
+	collection := returnProjectsCollection("projects")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrProjectNotFound
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "_name", Value: entry.Name},
+			{Key: "_color", Value: entry.Color},
+			{Key: "_icon", Value: entry.Icon},
+			{Key: "_archived", Value: entry.Archived},
+			{Key: "_updated_at", Value: time.Now()},
+		}},
+	}
+
+	res, err := collection.UpdateOne(context.TODO(), bson.M{"_id": mongoID, "_user_id": userID}, update)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrProjectNotFound
+	}
+	return nil
+}
+
+// ReorderProjects stores the position of every project in ids
+func (p *Project) ReorderProjects(userID primitive.ObjectID, ids []string) error {
+	collection := returnProjectsCollection("projects")
+
+	var models []mongo.WriteModel
+	for position, id := range ids {
+		mongoID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return ErrProjectNotFound
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": mongoID, "_user_id": userID}).
+			SetUpdate(bson.M{"$set": bson.M{"_position": position}}))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+
+	res, err := collection.BulkWrite(context.TODO(), models)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount != int64(len(models)) {
+		return ErrProjectNotFound
+	}
+	return nil
+}
+
+// CountProjectTodos counts the todos in a project
+func (p *Project) CountProjectTodos(userID primitive.ObjectID, id string) (int64, error) {
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, ErrProjectNotFound
+	}
+	return returnTodosCollection("todos").CountDocuments(context.TODO(), bson.M{"_user_id": userID, "_project_id": mongoID, "_deleted_at": nil})
+}
+
+// DeleteProject deletes the project. Its todos are moved to target, nil
+// being the inbox, or deleted together with their details depending on
+// mode. An empty mode only deletes projects without todos.
+func (p *Project) DeleteProject(userID primitive.ObjectID, id string, mode string, target *primitive.ObjectID) (DeleteProjectResult, error) {
+	project, err := p.GetProjectById(userID, id)
+	if err != nil {
+		return DeleteProjectResult{}, err
+	}
+	mongoID, _ := primitive.ObjectIDFromHex(project.ID)
+	if mode == ProjectTodosMove && target != nil && *target == mongoID {
+		return DeleteProjectResult{}, ErrProjectMoveIntoSelf
+	}
+	result := DeleteProjectResult{ProjectID: project.ID}
+	todos := returnTodosCollection("todos")
+	// Trashed todos stay behind, restoring them after the project is gone
+	// puts them in the inbox
+	filter := bson.M{"_user_id": userID, "_project_id": mongoID, "_deleted_at": nil}
+
+	switch mode {
+	case ProjectTodosMove:
+		cursor, err := todos.Find(context.TODO(), filter, options.Find().SetSort(rankSort).SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			log.Println(err)
+			return result, err
+		}
+		var ids []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(context.TODO(), &ids); err != nil {
+			log.Println(err)
+			return result, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		// The todos go to the end of the target list, keeping their order
+		last := lastRank(todos, todoListFilter(userID, target))
+		ranks := SpreadRanks(len(ids))
+		now := time.Now()
+		models := make([]mongo.WriteModel, len(ids))
+		for i, doc := range ids {
+			update := bson.M{"$unset": bson.M{"_project_id": ""}, "$set": bson.M{"_rank": last + ranks[i], "_updated_at": now}, "$inc": incVersion}
+			if target != nil {
+				update = bson.M{"$set": bson.M{"_project_id": *target, "_rank": last + ranks[i], "_updated_at": now}, "$inc": incVersion}
+			}
+			models[i] = mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": doc.ID}).SetUpdate(update)
+		}
+		res, err := todos.BulkWrite(context.TODO(), models)
+		if err != nil {
+			log.Println(err)
+			return result, err
+		}
+		result.TodosMoved = res.ModifiedCount
+
+	case ProjectTodosDelete:
+		cursor, err := todos.Find(context.TODO(), filter, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			log.Println(err)
+			return result, err
+		}
+		var ids []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(context.TODO(), &ids); err != nil {
+			log.Println(err)
+			return result, err
+		}
+		todo := Todo{}
+		for _, doc := range ids {
+			_, err := todo.TrashTodo(userID, doc.ID.Hex(), AnyVersion)
+			if errors.Is(err, ErrTodoNotFound) {
+				continue
+			}
+			if err != nil {
+				return result, err
+			}
+			result.TodosDeleted++
+		}
+
+	default:
+		count, err := todos.CountDocuments(context.TODO(), filter)
+		if err != nil {
+			log.Println(err)
+			return result, err
+		}
+		if count > 0 {
+			return result, ErrProjectNotEmpty
+		}
+	}
+
+	_, err = returnProjectsCollection("projects").DeleteOne(context.TODO(), bson.M{"_id": mongoID})
+	if err != nil {
+		log.Println(err)
+		return result, err
+	}
+	return result, nil
+}
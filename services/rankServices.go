@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"strings"
+)
+
+// Ranks order the todos of a list and the subtasks of a todo. A rank is a
+// string of base 36 digits compared as a plain string, so there is always
+// room for another rank between two different ranks and moving an item only
+// rewrites that item. Ranks never end in the lowest digit, which is what
+// keeps that room.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// MaxRankLength is the length past which a list gets rebalanced
+const MaxRankLength = 8
+
+var ErrRankCollision = errors.New("no rank between equal ranks")
+
+func rankDigit(rank string, i int) int {
+	if i >= len(rank) {
+		return 0
+	}
+	return strings.IndexByte(rankDigits, rank[i])
+}
+
+// RankBetween returns a rank sorting after a and before b. An empty a is the
+// start of the list, an empty b its end.
+func RankBetween(a, b string) (string, error) {
+	if b != "" && a >= b {
+		return "", ErrRankCollision
+	}
+	return midRank(a, b), nil
+}
+
+func midRank(a, b string) string {
+	// Keep the prefix both share, a is read as padded with zeros
+	if b != "" {
+		n := 0
+		for n < len(b) && rankDigit(a, n) == rankDigit(b, n) {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midRank(rest, b[n:])
+		}
+	}
+
+	low := rankDigit(a, 0)
+	high := len(rankDigits)
+	if b != "" {
+		high = rankDigit(b, 0)
+	}
+	if high-low > 1 {
+		return string(rankDigits[(low+high)/2])
+	}
+	// Neighbouring digits, b cut to its first digit still sorts after a
+	// when b is longer
+	if b != "" && len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(rankDigits[low]) + midRank(rest, "")
+}
+
+// SpreadRanks returns count ranks spread evenly over the rank space, all of
+// the same short length
+func SpreadRanks(count int) []string {
+	width, space := 1, len(rankDigits)
+	for space <= count {
+		width++
+		space *= len(rankDigits)
+	}
+
+	ranks := make([]string, count)
+	for i := range ranks {
+		value := (i + 1) * space / (count + 1)
+		digits := make([]byte, width)
+		for j := width - 1; j >= 0; j-- {
+			digits[j] = rankDigits[value%len(rankDigits)]
+			value /= len(rankDigits)
+		}
+		ranks[i] = strings.TrimRight(string(digits), "0")
+	}
+	return ranks
+}
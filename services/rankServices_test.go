@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRankBetween(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"empty list", "", ""},
+		{"start of list", "", "i"},
+		{"end of list", "i", ""},
+		{"room between", "a", "k"},
+		{"adjacent digits", "a", "b"},
+		{"adjacent with longer b", "a", "b5"},
+		{"shared prefix", "a1", "a2"},
+		{"prefix of b", "a", "a1"},
+		{"lowest digits", "", "01"},
+		{"highest digit", "z", ""},
+		{"after many highest", "zzzz", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rank, err := RankBetween(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("RankBetween(%q, %q): %v", tt.a, tt.b, err)
+			}
+			if rank <= tt.a || (tt.b != "" && rank >= tt.b) {
+				t.Errorf("RankBetween(%q, %q) = %q, not between", tt.a, tt.b, rank)
+			}
+			if strings.HasSuffix(rank, "0") {
+				t.Errorf("RankBetween(%q, %q) = %q, ends in the lowest digit", tt.a, tt.b, rank)
+			}
+		})
+	}
+}
+
+func TestRankBetweenCollision(t *testing.T) {
+	for _, pair := range [][2]string{{"a", "a"}, {"b", "a"}} {
+		if _, err := RankBetween(pair[0], pair[1]); !errors.Is(err, ErrRankCollision) {
+			t.Errorf("RankBetween(%q, %q) error = %v, want ErrRankCollision", pair[0], pair[1], err)
+		}
+	}
+}
+
+func TestRankBetweenRepeatedInserts(t *testing.T) {
+	// Inserting again and again right after the same item keeps working,
+	// the ranks only grow longer until the list is rebalanced
+	a, b := "a", "b"
+	for i := 0; i < 50; i++ {
+		rank, err := RankBetween(a, b)
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		if rank <= a || rank >= b {
+			t.Fatalf("insert %d: %q not between %q and %q", i, rank, a, b)
+		}
+		b = rank
+	}
+	if len(b) <= MaxRankLength {
+		t.Errorf("rank %q did not grow past MaxRankLength", b)
+	}
+}
+
+func TestSpreadRanks(t *testing.T) {
+	for _, count := range []int{0, 1, 2, 35, 36, 100, 2000} {
+		ranks := SpreadRanks(count)
+		if len(ranks) != count {
+			t.Fatalf("SpreadRanks(%d) returned %d ranks", count, len(ranks))
+		}
+		for i, rank := range ranks {
+			if rank == "" || strings.HasSuffix(rank, "0") {
+				t.Errorf("SpreadRanks(%d)[%d] = %q", count, i, rank)
+			}
+			if len(rank) > MaxRankLength {
+				t.Errorf("SpreadRanks(%d)[%d] = %q, needs rebalancing itself", count, i, rank)
+			}
+			if i > 0 && ranks[i-1] >= rank {
+				t.Errorf("SpreadRanks(%d) not increasing at %d: %q, %q", count, i, ranks[i-1], rank)
+			}
+		}
+	}
+}
+
+func TestSpreadRanksLeaveRoom(t *testing.T) {
+	// A rebalanced list has room before, between and after its items
+	ranks := SpreadRanks(10)
+	bounds := append(append([]string{""}, ranks...), "")
+	for i := 0; i+1 < len(bounds); i++ {
+		if _, err := RankBetween(bounds[i], bounds[i+1]); err != nil {
+			t.Errorf("no rank between %q and %q: %v", bounds[i], bounds[i+1], err)
+		}
+	}
+	// Appending after the last rank, as moves to another list do, sorts
+	// after the list's own ranks
+	last := "k"
+	for _, rank := range SpreadRanks(5) {
+		if last+rank <= last {
+			t.Errorf("%q does not sort after %q", last+rank, last)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Recurrence makes a todo repeat. Every occurrence is its own todo, they
+// share SeriesID and the rule is evaluated from DTStart in Timezone.
+type Recurrence struct {
+	RRule    string             `json:"rrule" bson:"_rrule"`
+	Timezone string             `json:"timezone" bson:"_timezone"`
+	SeriesID primitive.ObjectID `json:"series_id" bson:"_series_id"`
+	DTStart  time.Time          `json:"dtstart" bson:"_dtstart"`
+	// NextID is the occurrence generated when this one was completed
+	NextID *primitive.ObjectID `json:"next_id,omitempty" bson:"_next_id,omitempty"`
+}
+
+// Which occurrences of a series an edit applies to
+const (
+	ScopeThis   = "this"
+	ScopeFuture = "future"
+)
+
+var ErrNotRecurring = errors.New("todo does not repeat")
+
+// NewRecurrence validates the rule and timezone and starts a new series
+// at dtstart.
+func NewRecurrence(rrule string, timezone string, dtstart time.Time) (*Recurrence, error) {
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	rec := &Recurrence{
+		RRule:    rrule,
+		Timezone: timezone,
+		SeriesID: primitive.NewObjectID(),
+		DTStart:  dtstart,
+	}
+	if _, _, err := rec.parse(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r Recurrence) parse() (RRule, *time.Location, error) {
+	rule, err := ParseRRule(r.RRule)
+	if err != nil {
+		return RRule{}, nil, err
+	}
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return RRule{}, nil, errors.New("unknown timezone " + r.Timezone)
+	}
+	return rule, loc, nil
+}
+
+// Upcoming returns up to limit occurrences after "after"
+func (r Recurrence) Upcoming(after time.Time, limit int) ([]time.Time, error) {
+	rule, loc, err := r.parse()
+	if err != nil {
+		return nil, err
+	}
+	return rule.Occurrences(r.DTStart, loc, after, limit), nil
+}
+
+// OccurrenceTime is the date a repeating todo is scheduled for, its due
+// date or otherwise its start date.
+func (t Todo) OccurrenceTime() *time.Time {
+	if t.DateDue != nil {
+		return t.DateDue
+	}
+	return t.DateStart
+}
+
+// shiftDates moves both dates of the todo by d
+func (t *Todo) shiftDates(d time.Duration) {
+	if t.DateStart != nil {
+		start := t.DateStart.Add(d)
+		t.DateStart = &start
+	}
+	if t.DateDue != nil {
+		due := t.DateDue.Add(d)
+		t.DateDue = &due
+	}
+}
+
+// SetTodoRecurrence stores the recurrence of a todo, nil stops it repeating
+func (t *Todo) SetTodoRecurrence(userID primitive.ObjectID, id string, rec *Recurrence) error {
+	collection := returnTodosCollection("todos")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+
+	update := bson.M{"$set": bson.M{"_recurrence": rec, "_updated_at": time.Now()}, "$inc": incVersion}
+	if rec == nil {
+		update = bson.M{"$unset": bson.M{"_recurrence": ""}, "$set": bson.M{"_updated_at": time.Now()}, "$inc": incVersion}
+	}
+
+	res, err := collection.UpdateOne(context.Background(), bson.M{"_id": mongoID, "_user_id": userID}, update)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTodoNotFound
+	}
+	return nil
+}
+
+// futureOccurrences returns the open occurrences of the series scheduled
+// after the given todo
+func futureOccurrences(todo Todo) ([]Todo, error) {
+	collection := returnTodosCollection("todos")
+	at := todo.OccurrenceTime()
+	if todo.Recurrence == nil || at == nil {
+		return nil, ErrNotRecurring
+	}
+
+	filter := bson.M{
+		"_user_id":               todo.UserID,
+		"_recurrence._series_id": todo.Recurrence.SeriesID,
+		"_completed":             false,
+		"_deleted_at":            nil,
+		"_id":                    bson.M{"$ne": todo.mongoID()},
+		"$or": bson.A{
+			bson.M{"_date_due": bson.M{"$gt": *at}},
+			bson.M{"_date_due": bson.M{"$exists": false}, "_date_start": bson.M{"$gt": *at}},
+		},
+	}
+	cursor, err := collection.Find(context.TODO(), filter)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var todos []Todo
+	if err := cursor.All(context.TODO(), &todos); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return todos, nil
+}
+
+func (t Todo) mongoID() primitive.ObjectID {
+	mongoID, _ := primitive.ObjectIDFromHex(t.ID)
+	return mongoID
+}
+
+// UpdateFutureOccurrences applies an edit of todo to the open occurrences
+// scheduled after it: task and auto_complete are copied, dates move by the
+// same amount the edit moved todo and the series is re-anchored so new
+// occurrences follow. Returns how many occurrences changed.
+func (t *Todo) UpdateFutureOccurrences(before Todo, after Todo) (int64, error) {
+	todos, err := futureOccurrences(before)
+	if err != nil {
+		return 0, err
+	}
+
+	var shift time.Duration
+	if from, to := before.OccurrenceTime(), after.OccurrenceTime(); from != nil && to != nil {
+		shift = to.Sub(*from)
+	}
+
+	collection := returnTodosCollection("todos")
+	var models []mongo.WriteModel
+	for _, todo := range todos {
+		todo.shiftDates(shift)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": todo.mongoID()}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"_task":                after.Task,
+				"_auto_complete":       after.AutoComplete,
+				"_date_start":          todo.DateStart,
+				"_date_due":            todo.DateDue,
+				"_recurrence._dtstart": todo.Recurrence.DTStart.Add(shift),
+				"_updated_at":          time.Now(),
+			}, "$inc": incVersion}))
+	}
+
+	// The edited todo anchors the series from now on
+	if shift != 0 {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": before.mongoID()}).
+			SetUpdate(bson.M{"$set": bson.M{"_recurrence._dtstart": before.Recurrence.DTStart.Add(shift)}}))
+	}
+	if len(models) == 0 {
+		return 0, nil
+	}
+
+	if _, err := collection.BulkWrite(context.TODO(), models); err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	var reminders Reminder
+	for _, todo := range todos {
+		if err := reminders.RescheduleReminders(todo.ID, todo.DateDue); err != nil {
+			log.Println(err)
+		}
+	}
+	return int64(len(todos)), nil
+}
+
+// SplitSeries gives todo and its open future occurrences a new rule. The
+// earlier occurrences keep the old one, so history is not rewritten. A nil
+// rec stops all of them repeating.
+func (t *Todo) SplitSeries(todo Todo, rec *Recurrence) (int64, error) {
+	todos, err := futureOccurrences(todo)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := bson.A{todo.mongoID()}
+	for _, future := range todos {
+		ids = append(ids, future.mongoID())
+	}
+
+	update := bson.M{"$set": bson.M{"_recurrence": rec, "_updated_at": time.Now()}, "$inc": incVersion}
+	if rec == nil {
+		update = bson.M{"$unset": bson.M{"_recurrence": ""}, "$set": bson.M{"_updated_at": time.Now()}, "$inc": incVersion}
+	}
+
+	res, err := returnTodosCollection("todos").UpdateMany(context.TODO(), bson.M{"_id": bson.M{"$in": ids}}, update)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// CreateNextOccurrence creates the occurrence following a completed todo,
+// together with a copy of its details and subtasks. It returns false
+// when the series has ended or the next occurrence already exists.
+func (t *Todo) CreateNextOccurrence(todo Todo) (Todo, bool, error) {
+	at := todo.OccurrenceTime()
+	if todo.Recurrence == nil || at == nil || todo.Recurrence.NextID != nil {
+		return Todo{}, false, nil
+	}
+
+	rule, loc, err := todo.Recurrence.parse()
+	if err != nil {
+		return Todo{}, false, err
+	}
+	nextAt, ok := rule.Next(todo.Recurrence.DTStart, loc, *at)
+	if !ok {
+		return Todo{}, false, nil
+	}
+
+	// Claim the todo first so two completions never create two occurrences
+	collection := returnTodosCollection("todos")
+	nextID := primitive.NewObjectID()
+	claim, err := collection.UpdateOne(
+		context.TODO(),
+		bson.M{"_id": todo.mongoID(), "_recurrence._next_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"_recurrence._next_id": nextID}},
+	)
+	if err != nil {
+		log.Println(err)
+		return Todo{}, false, err
+	}
+	if claim.ModifiedCount == 0 {
+		return Todo{}, false, nil
+	}
+
+	// The occurrence is a todo of its own, at the end of its list
+	next := todo
+	next.ID = nextID.Hex()
+	next.Rank = lastRank(collection, todoListFilter(todo.UserID, todo.ProjectID))
+	next.Version = 1
+	next.Completed = false
+	next.CompletedAt = nil
+	next.ArchivedAt = nil
+	next.BlockedBy = nil
+	next.shiftDates(nextAt.Sub(*at))
+	rec := *todo.Recurrence
+	rec.NextID = nil
+	next.Recurrence = &rec
+	next.CreatedAt = time.Now()
+	next.UpdatedAt = time.Now()
+	next.SchemaVersion = CurrentSchemaVersion
+
+	// Todo keeps its id as a string, insert with the claimed ObjectID instead
+	doc, err := bson.Marshal(next)
+	if err != nil {
+		return Todo{}, false, err
+	}
+	var insert bson.D
+	if err := bson.Unmarshal(doc, &insert); err != nil {
+		return Todo{}, false, err
+	}
+	insert = append(bson.D{{Key: "_id", Value: nextID}}, removeKey(insert, "_id")...)
+	if _, err := collection.InsertOne(context.TODO(), insert); err != nil {
+		log.Println(err)
+		collection.UpdateOne(context.TODO(), bson.M{"_id": todo.mongoID()}, bson.M{"$unset": bson.M{"_recurrence._next_id": ""}})
+		return Todo{}, false, err
+	}
+
+	copyOccurrenceChildren(todo.mongoID(), nextID)
+	return next, true, nil
+}
+
+// copyOccurrenceChildren copies details and subtasks to the next
+// occurrence, both start over as not done. Failures are logged only, the
+// occurrence itself already exists.
+func copyOccurrenceChildren(from, to primitive.ObjectID) {
+	ctx := context.TODO()
+
+	var details TodoDetails
+	err := returnTodoDetailsCollection("todo_details").FindOne(ctx, bson.M{"_todo_id": from, "_deleted_at": nil}).Decode(&details)
+	if err == nil {
+		details.TodoID = to
+		details.StatusDetails = StatusTodo
+		if _, err := details.InsertTodoDetails(details); err != nil {
+			log.Println("Could not copy todo details: ", err)
+		}
+	} else if err != mongo.ErrNoDocuments {
+		log.Println(err)
+	}
+
+	opts := options.Find().SetSort(subtaskSort)
+	cursor, err := returnSubtasksCollection("subtasks").Find(ctx, bson.M{"_todo_id": from}, opts)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var subtasks []Subtask
+	if err := cursor.All(ctx, &subtasks); err != nil {
+		log.Println(err)
+		return
+	}
+	for _, subtask := range subtasks {
+		subtask.TodoID = to
+		if _, err := subtask.InsertSubtask(subtask); err != nil {
+			log.Println("Could not copy subtask: ", err)
+		}
+	}
+
+	copyOccurrenceReminders(from, to)
+}
+
+// copyOccurrenceReminders carries reminders relative to the due date over to
+// the next occurrence, absolute ones only belong to the occurrence they were
+// set on
+func copyOccurrenceReminders(from, to primitive.ObjectID) {
+	ctx := context.TODO()
+
+	var next Todo
+	if err := returnTodosCollection("todos").FindOne(ctx, bson.M{"_id": to}).Decode(&next); err != nil {
+		log.Println(err)
+		return
+	}
+
+	filter := bson.M{"_todo_id": from, "_offset_minutes": bson.M{"$exists": true}}
+	cursor, err := returnRemindersCollection("reminders").Find(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var reminders []Reminder
+	if err := cursor.All(ctx, &reminders); err != nil {
+		log.Println(err)
+		return
+	}
+	for _, reminder := range reminders {
+		if _, err := reminder.InsertReminder(next, Reminder{OffsetMinutes: reminder.OffsetMinutes}); err != nil {
+			log.Println("Could not copy reminder: ", err)
+		}
+	}
+}
+
+func removeKey(doc bson.D, key string) bson.D {
+	out := doc[:0]
+	for _, e := range doc {
+		if e.Key != key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reminder notifies the owner of a todo at RemindAt, or OffsetMinutes
+// before its due date. FireAt is when it goes out and is recomputed when
+// the due date moves.
+type Reminder struct {
+	ID            string             `json:"id,omitempty" bson:"_id,omitempty"`
+	TodoID        primitive.ObjectID `json:"todo_id" bson:"_todo_id"`
+	UserID        primitive.ObjectID `json:"user_id" bson:"_user_id"`
+	RemindAt      *time.Time         `json:"remind_at,omitempty" bson:"_remind_at,omitempty"`
+	OffsetMinutes *int               `json:"offset_minutes,omitempty" bson:"_offset_minutes,omitempty"`
+	FireAt        *time.Time         `json:"fire_at" bson:"_fire_at"`
+	SentAt        *time.Time         `json:"sent_at" bson:"_sent_at,omitempty"`
+	// FailedAt is set once delivery gave up after MaxReminderAttempts
+	FailedAt  *time.Time `json:"failed_at,omitempty" bson:"_failed_at,omitempty"`
+	Attempts  int        `json:"attempts" bson:"_attempts"`
+	LastError string     `json:"last_error,omitempty" bson:"_last_error,omitempty"`
+	// The scheduler instance delivering the reminder holds it until
+	// LeaseUntil, so other instances skip it
+	LeaseOwner string     `json:"-" bson:"_lease_owner,omitempty"`
+	LeaseUntil *time.Time `json:"-" bson:"_lease_until,omitempty"`
+	CreatedAt  time.Time  `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// MaxReminderAttempts is how often delivery is tried before giving up
+const MaxReminderAttempts = 5
+
+var ErrReminderNotFound = errors.New("reminder not found")
+
+func NewReminderService(mongo *mongo.Client) Reminder {
+	client = mongo
+	return Reminder{}
+}
+
+func returnRemindersCollection(collection string) *mongo.Collection {
+	return client.Database("todos_db").Collection(collection)
+}
+
+// fireAt resolves when the reminder goes out for a todo due at due. Offset
+// reminders of a todo without due date never fire.
+func (r Reminder) fireAt(due *time.Time) *time.Time {
+	if r.RemindAt != nil {
+		return r.RemindAt
+	}
+	if r.OffsetMinutes == nil || due == nil {
+		return nil
+	}
+	at := due.Add(-time.Duration(*r.OffsetMinutes) * time.Minute)
+	return &at
+}
+
+// GetRemindersByTodoId returns the reminders of a todo, earliest first
+func (r *Reminder) GetRemindersByTodoId(todoID string) ([]Reminder, error) {
+	collection := returnRemindersCollection("reminders")
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_fire_at", Value: 1}})
+	cursor, err := collection.Find(context.TODO(), bson.M{"_todo_id": todoOID}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	reminders := []Reminder{}
+	if err := cursor.All(context.TODO(), &reminders); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return reminders, nil
+}
+
+// GetReminderById returns one reminder of a todo
+func (r *Reminder) GetReminderById(todoID string, id string) (Reminder, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return Reminder{}, ErrReminderNotFound
+	}
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Reminder{}, ErrReminderNotFound
+	}
+
+	var reminder Reminder
+	err = returnRemindersCollection("reminders").FindOne(context.TODO(), bson.M{"_id": mongoID, "_todo_id": todoOID}).Decode(&reminder)
+	if err == mongo.ErrNoDocuments {
+		return Reminder{}, ErrReminderNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Reminder{}, err
+	}
+	return reminder, nil
+}
+
+// InsertReminder schedules a reminder for the todo
+func (r *Reminder) InsertReminder(todo Todo, entry Reminder) (Reminder, error) {
+	collection := returnRemindersCollection("reminders")
+	todoOID, err := primitive.ObjectIDFromHex(todo.ID)
+	if err != nil {
+		return Reminder{}, ErrTodoNotFound
+	}
+
+	reminder := Reminder{
+		TodoID:        todoOID,
+		UserID:        todo.UserID,
+		RemindAt:      entry.RemindAt,
+		OffsetMinutes: entry.OffsetMinutes,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	reminder.FireAt = reminder.fireAt(todo.DateDue)
+
+	res, err := collection.InsertOne(context.TODO(), reminder)
+	if err != nil {
+		log.Println("Error: ", err)
+		return Reminder{}, err
+	}
+	reminder.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return reminder, nil
+}
+
+// DeleteReminder
+func (r *Reminder) DeleteReminder(todoID string, id string) error {
+	collection := returnRemindersCollection("reminders")
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return ErrReminderNotFound
+	}
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrReminderNotFound
+	}
+
+	res, err := collection.DeleteOne(context.TODO(), bson.M{"_id": mongoID, "_todo_id": todoOID})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrReminderNotFound
+	}
+	return nil
+}
+
+// RescheduleReminders moves the unsent offset reminders of a todo after its
+// due date changed
+func (r *Reminder) RescheduleReminders(todoID string, due *time.Time) error {
+	collection := returnRemindersCollection("reminders")
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_todo_id":        todoOID,
+		"_offset_minutes": bson.M{"$exists": true},
+		"_sent_at":        bson.M{"$exists": false},
+	}
+	cursor, err := collection.Find(context.TODO(), filter)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	var reminders []Reminder
+	if err := cursor.All(context.TODO(), &reminders); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	var models []mongo.WriteModel
+	for _, reminder := range reminders {
+		mongoID, _ := primitive.ObjectIDFromHex(reminder.ID)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": mongoID}).
+			SetUpdate(bson.M{
+				"$set":   bson.M{"_fire_at": reminder.fireAt(due), "_attempts": 0, "_updated_at": time.Now()},
+				"$unset": bson.M{"_failed_at": "", "_last_error": ""},
+			}))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+
+	if _, err := collection.BulkWrite(context.TODO(), models); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// ClaimDueReminder leases one reminder that is due at now to owner, it
+// returns ErrReminderNotFound when nothing is due. The lease is taken in a
+// single update so two instances never deliver the same reminder.
+func (r *Reminder) ClaimDueReminder(ctx context.Context, owner string, now time.Time, lease time.Duration) (Reminder, error) {
+	collection := returnRemindersCollection("reminders")
+
+	// Null also matches missing fields and, unlike $exists, uses the index
+	filter := bson.M{
+		"_sent_at":   nil,
+		"_failed_at": nil,
+		"_fire_at":   bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"_lease_until": bson.M{"$exists": false}},
+			bson.M{"_lease_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"_lease_owner": owner, "_lease_until": now.Add(lease)},
+		"$inc": bson.M{"_attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "_fire_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var reminder Reminder
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&reminder)
+	if err == mongo.ErrNoDocuments {
+		return Reminder{}, ErrReminderNotFound
+	}
+	if err != nil {
+		return Reminder{}, err
+	}
+	return reminder, nil
+}
+
+// PostponeReminder hands a leased reminder back to be delivered at until,
+// the attempt it was claimed for does not count
+func (r *Reminder) PostponeReminder(ctx context.Context, reminder Reminder, owner string, until time.Time) error {
+	mongoID, err := primitive.ObjectIDFromHex(reminder.ID)
+	if err != nil {
+		return ErrReminderNotFound
+	}
+
+	_, err = returnRemindersCollection("reminders").UpdateOne(ctx,
+		bson.M{"_id": mongoID, "_lease_owner": owner},
+		bson.M{
+			"$set":   bson.M{"_fire_at": until, "_updated_at": time.Now()},
+			"$unset": bson.M{"_lease_owner": "", "_lease_until": ""},
+			"$inc":   bson.M{"_attempts": -1},
+		},
+	)
+	return err
+}
+
+// CompleteReminder records the outcome of a delivery by the lease owner. A
+// failed reminder is retried once the lease ran out, until it used up its
+// attempts.
+func (r *Reminder) CompleteReminder(ctx context.Context, reminder Reminder, owner string, deliveryErr error) error {
+	collection := returnRemindersCollection("reminders")
+	mongoID, err := primitive.ObjectIDFromHex(reminder.ID)
+	if err != nil {
+		return ErrReminderNotFound
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"_sent_at": now, "_updated_at": now},
+		"$unset": bson.M{"_lease_owner": "", "_lease_until": "", "_last_error": ""},
+	}
+	if deliveryErr != nil {
+		set := bson.M{"_last_error": deliveryErr.Error(), "_updated_at": now}
+		if reminder.Attempts >= MaxReminderAttempts {
+			set["_failed_at"] = now
+		}
+		update = bson.M{"$set": set, "$unset": bson.M{"_lease_owner": ""}}
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": mongoID, "_lease_owner": owner}, update)
+	return err
+}
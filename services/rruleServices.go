@@ -0,0 +1,344 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRule is the subset of an RFC 5545 recurrence rule we support: FREQ
+// (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, COUNT, UNTIL, BYDAY with
+// optional ordinals (-1FR is the last Friday), BYMONTHDAY and BYMONTH.
+// Weeks start on Monday.
+type RRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      *time.Time
+	ByDay      []RRuleWeekday
+	ByMonthDay []int
+	ByMonth    []time.Month
+}
+
+// RRuleWeekday is a BYDAY entry, N is 0 for every such weekday in the period
+type RRuleWeekday struct {
+	N   int
+	Day time.Weekday
+}
+
+// maxRRulePeriods stops rules that can never produce an occurrence, like
+// BYMONTH=2;BYMONTHDAY=30, from looping forever.
+const maxRRulePeriods = 5000
+
+var allMonths = []time.Month{
+	time.January, time.February, time.March, time.April, time.May, time.June,
+	time.July, time.August, time.September, time.October, time.November, time.December,
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// ParseRRule parses a rule such as "FREQ=WEEKLY;BYDAY=MO,WE,FR", the
+// "RRULE:" prefix is optional.
+func ParseRRule(value string) (RRule, error) {
+	rule := RRule{Interval: 1}
+	value = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(value)), "RRULE:")
+	if value == "" {
+		return RRule{}, errors.New("rrule is empty")
+	}
+
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok || val == "" {
+			return RRule{}, fmt.Errorf("invalid rrule part %q", part)
+		}
+
+		switch key {
+		case "FREQ":
+			switch val {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rule.Freq = val
+			default:
+				return RRule{}, fmt.Errorf("unsupported FREQ %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return RRule{}, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return RRule{}, fmt.Errorf("invalid COUNT %q", val)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseRRuleTime(val)
+			if err != nil {
+				return RRule{}, fmt.Errorf("invalid UNTIL %q", val)
+			}
+			rule.Until = &until
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, err := parseRRuleWeekday(day)
+				if err != nil {
+					return RRule{}, err
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return RRule{}, fmt.Errorf("invalid BYMONTHDAY %q", day)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(month)
+				if err != nil || n < 1 || n > 12 {
+					return RRule{}, fmt.Errorf("invalid BYMONTH %q", month)
+				}
+				rule.ByMonth = append(rule.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			if val != "MO" {
+				return RRule{}, errors.New("only WKST=MO is supported")
+			}
+		default:
+			return RRule{}, fmt.Errorf("unsupported rrule part %s", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return RRule{}, errors.New("rrule needs a FREQ")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return RRule{}, errors.New("rrule cannot have both COUNT and UNTIL")
+	}
+	for _, weekday := range rule.ByDay {
+		if weekday.N != 0 && rule.Freq != "MONTHLY" && rule.Freq != "YEARLY" {
+			return RRule{}, errors.New("BYDAY ordinals need a MONTHLY or YEARLY rule")
+		}
+	}
+	if rule.Freq == "WEEKLY" && len(rule.ByMonthDay) > 0 {
+		return RRule{}, errors.New("BYMONTHDAY cannot be used in a WEEKLY rule")
+	}
+	if rule.Freq == "YEARLY" && len(rule.ByDay) > 0 && len(rule.ByMonth) == 0 {
+		return RRule{}, errors.New("BYDAY in a YEARLY rule needs BYMONTH")
+	}
+	return rule, nil
+}
+
+func parseRRuleTime(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+func parseRRuleWeekday(value string) (RRuleWeekday, error) {
+	if len(value) < 2 {
+		return RRuleWeekday{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	day, ok := rruleWeekdays[value[len(value)-2:]]
+	if !ok {
+		return RRuleWeekday{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+
+	n := 0
+	if prefix := value[:len(value)-2]; prefix != "" {
+		var err error
+		n, err = strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return RRuleWeekday{}, fmt.Errorf("invalid BYDAY %q", value)
+		}
+	}
+	return RRuleWeekday{N: n, Day: day}, nil
+}
+
+// Occurrences returns up to limit occurrences strictly after "after" of the
+// series that starts at dtstart. dtstart fixes the time of day and where
+// INTERVAL and COUNT are counted from, loc is the timezone days are
+// counted in.
+func (rule RRule) Occurrences(dtstart time.Time, loc *time.Location, after time.Time, limit int) []time.Time {
+	dtstart = dtstart.In(loc)
+	var result []time.Time
+	seen := 0
+
+	for period := 0; period < maxRRulePeriods && len(result) < limit; period++ {
+		for _, candidate := range rule.periodCandidates(dtstart, period*rule.Interval) {
+			if candidate.Before(dtstart) {
+				continue
+			}
+			if rule.Until != nil && candidate.After(*rule.Until) {
+				return result
+			}
+			seen++
+			if rule.Count > 0 && seen > rule.Count {
+				return result
+			}
+			if candidate.After(after) {
+				result = append(result, candidate)
+				if len(result) == limit {
+					return result
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Next returns the first occurrence after "after", false when the series
+// has ended.
+func (rule RRule) Next(dtstart time.Time, loc *time.Location, after time.Time) (time.Time, bool) {
+	next := rule.Occurrences(dtstart, loc, after, 1)
+	if len(next) == 0 {
+		return time.Time{}, false
+	}
+	return next[0], true
+}
+
+// periodCandidates returns the sorted occurrences of the offset-th
+// day/week/month/year after the one dtstart falls in.
+func (rule RRule) periodCandidates(dtstart time.Time, offset int) []time.Time {
+	loc := dtstart.Location()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, loc)
+	}
+
+	var days []time.Time
+	switch rule.Freq {
+	case "DAILY":
+		day := at(dtstart.Year(), dtstart.Month(), dtstart.Day()+offset)
+		if rule.matchesWeekday(day) && rule.matchesMonthDay(day) && rule.matchesMonth(day.Month()) {
+			days = append(days, day)
+		}
+
+	case "WEEKLY":
+		sinceMonday := (int(dtstart.Weekday()) + 6) % 7
+		monday := at(dtstart.Year(), dtstart.Month(), dtstart.Day()-sinceMonday+7*offset)
+		for i := 0; i < 7; i++ {
+			day := at(monday.Year(), monday.Month(), monday.Day()+i)
+			if len(rule.ByDay) == 0 && day.Weekday() != dtstart.Weekday() {
+				continue
+			}
+			if rule.matchesWeekday(day) && rule.matchesMonth(day.Month()) {
+				days = append(days, day)
+			}
+		}
+
+	case "MONTHLY":
+		first := at(dtstart.Year(), dtstart.Month()+time.Month(offset), 1)
+		if rule.matchesMonth(first.Month()) {
+			days = rule.monthDays(first, dtstart.Day(), at)
+		}
+
+	case "YEARLY":
+		year := dtstart.Year() + offset
+		// BYMONTHDAY alone picks its days in every month of the year
+		months := rule.ByMonth
+		switch {
+		case len(months) > 0:
+		case len(rule.ByMonthDay) > 0:
+			months = allMonths
+		default:
+			months = []time.Month{dtstart.Month()}
+		}
+		for _, month := range months {
+			days = append(days, rule.monthDays(at(year, month, 1), dtstart.Day(), at)...)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// monthDays expands BYMONTHDAY and BYDAY within the month starting at
+// first. Without either the occurrence falls on defaultDay, months that
+// are too short are skipped as RFC 5545 requires.
+func (rule RRule) monthDays(first time.Time, defaultDay int, at func(int, time.Month, int) time.Time) []time.Time {
+	year, month := first.Year(), first.Month()
+	length := at(year, month+1, 0).Day()
+
+	var days []time.Time
+	if len(rule.ByMonthDay) == 0 && len(rule.ByDay) == 0 {
+		if defaultDay <= length {
+			days = append(days, at(year, month, defaultDay))
+		}
+		return days
+	}
+
+	for day := 1; day <= length; day++ {
+		date := at(year, month, day)
+		if len(rule.ByMonthDay) > 0 && !rule.matchesMonthDay(date) {
+			continue
+		}
+		if len(rule.ByDay) > 0 && !rule.matchesNthWeekday(date, length) {
+			continue
+		}
+		days = append(days, date)
+	}
+	return days
+}
+
+func (rule RRule) matchesWeekday(day time.Time) bool {
+	if len(rule.ByDay) == 0 {
+		return true
+	}
+	for _, weekday := range rule.ByDay {
+		if weekday.Day == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNthWeekday checks BYDAY with ordinals counted within the month
+func (rule RRule) matchesNthWeekday(day time.Time, monthLength int) bool {
+	nth := (day.Day()-1)/7 + 1
+	nthFromEnd := -((monthLength-day.Day())/7 + 1)
+	for _, weekday := range rule.ByDay {
+		if weekday.Day != day.Weekday() {
+			continue
+		}
+		if weekday.N == 0 || weekday.N == nth || weekday.N == nthFromEnd {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule RRule) matchesMonthDay(day time.Time) bool {
+	if len(rule.ByMonthDay) == 0 {
+		return true
+	}
+	length := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+	for _, monthDay := range rule.ByMonthDay {
+		if monthDay == day.Day() || length+monthDay+1 == day.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule RRule) matchesMonth(month time.Month) bool {
+	if len(rule.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range rule.ByMonth {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
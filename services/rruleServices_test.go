@@ -0,0 +1,140 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRRuleRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;COUNT=3;UNTIL=20260101",
+		"FREQ=WEEKLY;BYDAY=-1FR",
+		"FREQ=WEEKLY;BYMONTHDAY=15",
+		"FREQ=YEARLY;BYDAY=MO",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=DAILY;WKST=SU",
+	}
+	for _, value := range tests {
+		if _, err := ParseRRule(value); err == nil {
+			t.Errorf("ParseRRule(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestRRuleOccurrences(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone data:", err)
+	}
+	date := func(loc *time.Location, year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart time.Time
+		loc     *time.Location
+		limit   int
+		want    []time.Time
+	}{
+		{
+			name:    "last friday of the month",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR",
+			dtstart: date(time.UTC, 2026, 1, 1, 9),
+			loc:     time.UTC,
+			limit:   3,
+			want:    []time.Time{date(time.UTC, 2026, 1, 30, 9), date(time.UTC, 2026, 2, 27, 9), date(time.UTC, 2026, 3, 27, 9)},
+		},
+		{
+			name:    "weekdays",
+			rule:    "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR",
+			dtstart: date(time.UTC, 2026, 1, 8, 9),
+			loc:     time.UTC,
+			limit:   4,
+			want:    []time.Time{date(time.UTC, 2026, 1, 8, 9), date(time.UTC, 2026, 1, 9, 9), date(time.UTC, 2026, 1, 12, 9), date(time.UTC, 2026, 1, 13, 9)},
+		},
+		{
+			name:    "count ends the series",
+			rule:    "FREQ=DAILY;INTERVAL=2;COUNT=3",
+			dtstart: date(time.UTC, 2026, 1, 1, 9),
+			loc:     time.UTC,
+			limit:   10,
+			want:    []time.Time{date(time.UTC, 2026, 1, 1, 9), date(time.UTC, 2026, 1, 3, 9), date(time.UTC, 2026, 1, 5, 9)},
+		},
+		{
+			name:    "until is inclusive",
+			rule:    "FREQ=WEEKLY;UNTIL=20260115T090000Z",
+			dtstart: date(time.UTC, 2026, 1, 1, 9),
+			loc:     time.UTC,
+			limit:   10,
+			want:    []time.Time{date(time.UTC, 2026, 1, 1, 9), date(time.UTC, 2026, 1, 8, 9), date(time.UTC, 2026, 1, 15, 9)},
+		},
+		{
+			name:    "short months are skipped",
+			rule:    "FREQ=MONTHLY;COUNT=3",
+			dtstart: date(time.UTC, 2026, 1, 31, 9),
+			loc:     time.UTC,
+			limit:   10,
+			want:    []time.Time{date(time.UTC, 2026, 1, 31, 9), date(time.UTC, 2026, 3, 31, 9), date(time.UTC, 2026, 5, 31, 9)},
+		},
+		{
+			name:    "yearly monthday without month is every month",
+			rule:    "FREQ=YEARLY;BYMONTHDAY=1",
+			dtstart: date(time.UTC, 2026, 1, 1, 9),
+			loc:     time.UTC,
+			limit:   3,
+			want:    []time.Time{date(time.UTC, 2026, 1, 1, 9), date(time.UTC, 2026, 2, 1, 9), date(time.UTC, 2026, 3, 1, 9)},
+		},
+		{
+			name:    "local time is kept across daylight saving time",
+			rule:    "FREQ=DAILY",
+			dtstart: date(berlin, 2026, 3, 28, 9),
+			loc:     berlin,
+			limit:   3,
+			want:    []time.Time{date(berlin, 2026, 3, 28, 9), date(berlin, 2026, 3, 29, 9), date(berlin, 2026, 3, 30, 9)},
+		},
+		{
+			name:    "impossible rule gives up",
+			rule:    "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30",
+			dtstart: date(time.UTC, 2026, 1, 1, 9),
+			loc:     time.UTC,
+			limit:   1,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := rule.Occurrences(tt.dtstart, tt.loc, tt.dtstart.Add(-time.Second), tt.limit)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("occurrence %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRRuleNextAfterEnd(t *testing.T) {
+	rule, err := ParseRRule("RRULE:FREQ=DAILY;COUNT=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dtstart := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	if next, ok := rule.Next(dtstart, time.UTC, dtstart); !ok || !next.Equal(dtstart.AddDate(0, 0, 1)) {
+		t.Errorf("Next = %v, %v, want the second day", next, ok)
+	}
+	if _, ok := rule.Next(dtstart, time.UTC, dtstart.AddDate(0, 0, 1)); ok {
+		t.Error("Next found an occurrence after COUNT ran out")
+	}
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Subtask struct {
+	ID        string             `json:"id,omitempty" bson:"_id,omitempty"`
+	TodoID    primitive.ObjectID `json:"todo_id" bson:"_todo_id"`
+	Title     string             `json:"title" bson:"_title"`
+	Completed bool               `json:"completed" bson:"_completed"`
+	Position  int                `json:"position" bson:"_position"`
+	Rank      string             `json:"rank" bson:"_rank,omitempty"`
+	CreatedAt time.Time          `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// SubtaskProgress is how many of the subtasks of a todo are done
+type SubtaskProgress struct {
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+}
+
+// AllDone reports whether there are subtasks and every one is done
+func (p SubtaskProgress) AllDone() bool {
+	return p.Total > 0 && p.Done == p.Total
+}
+
+var ErrSubtaskNotFound = errors.New("subtask not found")
+
+// subtaskSort orders subtasks, those from before ranks by their position
+var subtaskSort = bson.D{{Key: "_rank", Value: 1}, {Key: "_position", Value: 1}, {Key: "_created_at", Value: 1}}
+
+func NewSubtaskService(mongo *mongo.Client) Subtask {
+	client = mongo
+	return Subtask{}
+}
+
+func returnSubtasksCollection(collection string) *mongo.Collection {
+	return client.Database("todos_db").Collection(collection)
+}
+
+// subtaskFilter matches one subtask of a todo
+func subtaskFilter(todoID string, id string) (bson.M, error) {
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return nil, ErrSubtaskNotFound
+	}
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrSubtaskNotFound
+	}
+	return bson.M{"_id": mongoID, "_todo_id": todoOID}, nil
+}
+
+// GetSubtasksByTodoId returns the subtasks of a todo in their order
+func (s *Subtask) GetSubtasksByTodoId(todoID string) ([]Subtask, error) {
+	collection := returnSubtasksCollection("subtasks")
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(subtaskSort)
+	cursor, err := collection.Find(context.TODO(), bson.M{"_todo_id": todoOID}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	subtasks := []Subtask{}
+	if err := cursor.All(context.TODO(), &subtasks); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return subtasks, nil
+}
+
+// GetSubtaskById returns one subtask of a todo
+func (s *Subtask) GetSubtaskById(todoID string, id string) (Subtask, error) {
+	filter, err := subtaskFilter(todoID, id)
+	if err != nil {
+		return Subtask{}, err
+	}
+
+	var subtask Subtask
+	err = returnSubtasksCollection("subtasks").FindOne(context.TODO(), filter).Decode(&subtask)
+	if err == mongo.ErrNoDocuments {
+		return Subtask{}, ErrSubtaskNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Subtask{}, err
+	}
+	return subtask, nil
+}
+
+// GetSubtaskProgress counts the done and total subtasks of a todo
+func (s *Subtask) GetSubtaskProgress(todoID string) (SubtaskProgress, error) {
+	collection := returnSubtasksCollection("subtasks")
+	todoOID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return SubtaskProgress{}, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_todo_id": todoOID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"total": bson.M{"$sum": 1},
+			"done":  bson.M{"$sum": bson.M{"$cond": bson.A{"$_completed", 1, 0}}},
+		}}},
+	}
+	cursor, err := collection.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		log.Println(err)
+		return SubtaskProgress{}, err
+	}
+
+	var groups []SubtaskProgress
+	if err := cursor.All(context.TODO(), &groups); err != nil {
+		log.Println(err)
+		return SubtaskProgress{}, err
+	}
+	if len(groups) == 0 {
+		return SubtaskProgress{}, nil
+	}
+	return groups[0], nil
+}
+
+// GetSubtaskProgresses counts the done and total subtasks of several todos
+// at once, keyed by todo id. Todos without subtasks are left out.
+func (s *Subtask) GetSubtaskProgresses(todoIDs []string) (map[string]SubtaskProgress, error) {
+	byTodo := map[string]SubtaskProgress{}
+	ids := objectIDs(todoIDs)
+	if len(ids) == 0 {
+		return byTodo, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_todo_id": bson.M{"$in": ids}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$_todo_id",
+			"total": bson.M{"$sum": 1},
+			"done":  bson.M{"$sum": bson.M{"$cond": bson.A{"$_completed", 1, 0}}},
+		}}},
+	}
+	cursor, err := returnSubtasksCollection("subtasks").Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var groups []struct {
+		TodoID primitive.ObjectID `bson:"_id"`
+		Done   int64              `bson:"done"`
+		Total  int64              `bson:"total"`
+	}
+	if err := cursor.All(context.TODO(), &groups); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, group := range groups {
+		byTodo[group.TodoID.Hex()] = SubtaskProgress{Done: group.Done, Total: group.Total}
+	}
+	return byTodo, nil
+}
+
+// InsertSubtask adds the subtask after the other subtasks of its todo and
+// returns it as stored
+func (s *Subtask) InsertSubtask(entry Subtask) (Subtask, error) {
+	collection := returnSubtasksCollection("subtasks")
+
+	count, err := collection.CountDocuments(context.TODO(), bson.M{"_todo_id": entry.TodoID})
+	if err != nil {
+		log.Println(err)
+		return Subtask{}, err
+	}
+
+	subtask := Subtask{
+		TodoID:    entry.TodoID,
+		Title:     entry.Title,
+		Position:  int(count),
+		Rank:      lastRank(collection, bson.M{"_todo_id": entry.TodoID}),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	res, err := collection.InsertOne(context.TODO(), subtask)
+	if err != nil {
+		log.Println("Error: ", err)
+		return Subtask{}, err
+	}
+	subtask.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return subtask, nil
+}
+
+// UpdateSubtaskTitle renames a subtask
+func (s *Subtask) UpdateSubtaskTitle(todoID string, id string, title string) error {
+	collection := returnSubtasksCollection("subtasks")
+	filter, err := subtaskFilter(todoID, id)
+	if err != nil {
+		return err
+	}
+
+	res, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": bson.M{
+		"_title":      title,
+		"_updated_at": time.Now(),
+	}})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrSubtaskNotFound
+	}
+	return nil
+}
+
+// ToggleSubtask flips the completion state in a single update and returns
+// the subtask as stored afterwards
+func (s *Subtask) ToggleSubtask(todoID string, id string) (Subtask, error) {
+	collection := returnSubtasksCollection("subtasks")
+	filter, err := subtaskFilter(todoID, id)
+	if err != nil {
+		return Subtask{}, err
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"_completed":  bson.M{"$not": bson.A{"$_completed"}},
+			"_updated_at": "$$NOW",
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var subtask Subtask
+	err = collection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&subtask)
+	if err == mongo.ErrNoDocuments {
+		return Subtask{}, ErrSubtaskNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Subtask{}, err
+	}
+	return subtask, nil
+}
+
+// ReorderSubtasks stores the position of every subtask in ids
+func (s *Subtask) ReorderSubtasks(todoID string, ids []string) error {
+	collection := returnSubtasksCollection("subtasks")
+
+	ranks := SpreadRanks(len(ids))
+	var models []mongo.WriteModel
+	for position, id := range ids {
+		filter, err := subtaskFilter(todoID, id)
+		if err != nil {
+			return err
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$set": bson.M{"_position": position, "_rank": ranks[position]}}))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+
+	res, err := collection.BulkWrite(context.TODO(), models)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount != int64(len(models)) {
+		return ErrSubtaskNotFound
+	}
+	return nil
+}
+
+// DeleteSubtask
+func (s *Subtask) DeleteSubtask(todoID string, id string) error {
+	collection := returnSubtasksCollection("subtasks")
+	filter, err := subtaskFilter(todoID, id)
+	if err != nil {
+		return err
+	}
+
+	res, err := collection.DeleteOne(context.TODO(), filter)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrSubtaskNotFound
+	}
+	return nil
+}
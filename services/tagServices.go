@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Tag struct {
+	ID        string             `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"_user_id"`
+	Name      string             `json:"name" bson:"_name"`
+	NameKey   string             `json:"-" bson:"_name_key"`
+	Color     string             `json:"color" bson:"_color"`
+	CreatedAt time.Time          `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+type TagCount struct {
+	Tag
+	Count int64 `json:"count"`
+}
+
+type TagSummary struct {
+	Total    int64      `json:"total"`
+	Untagged int64      `json:"untagged"`
+	Items    []TagCount `json:"items"`
+}
+
+var (
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("a tag with this name already exists")
+	// ErrTagMergeSelf is returned when merging a tag into itself
+	ErrTagMergeSelf = errors.New("cannot merge a tag into itself")
+)
+
+func NewTagService(mongo *mongo.Client) Tag {
+	client = mongo
+	return Tag{}
+}
+
+func returnTagsCollection(collection string) *mongo.Collection {
+	return client.Database("todos_db").Collection(collection)
+}
+
+// tagNameKey is what tag names are compared by, "Work" and " work" are the
+// same tag.
+func tagNameKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// GetTagsByUser returns the user's tags sorted by name
+func (t *Tag) GetTagsByUser(userID primitive.ObjectID) ([]Tag, error) {
+	collection := returnTagsCollection("tags")
+	opts := options.Find().SetSort(bson.D{{Key: "_name_key", Value: 1}})
+
+	cursor, err := collection.Find(context.TODO(), bson.M{"_user_id": userID}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	tags := []Tag{}
+	if err := cursor.All(context.TODO(), &tags); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return tags, nil
+}
+
+// GetTagById returns one of the user's tags
+func (t *Tag) GetTagById(userID primitive.ObjectID, id string) (Tag, error) {
+	collection := returnTagsCollection("tags")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Tag{}, ErrTagNotFound
+	}
+
+	var tag Tag
+	err = collection.FindOne(context.TODO(), bson.M{"_id": mongoID, "_user_id": userID}).Decode(&tag)
+	if err == mongo.ErrNoDocuments {
+		return Tag{}, ErrTagNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Tag{}, err
+	}
+	return tag, nil
+}
+
+// GetTagsByNames resolves tag names, names without a tag are skipped
+func (t *Tag) GetTagsByNames(userID primitive.ObjectID, names []string) ([]Tag, error) {
+	collection := returnTagsCollection("tags")
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, tagNameKey(name))
+	}
+
+	cursor, err := collection.Find(context.TODO(), bson.M{"_user_id": userID, "_name_key": bson.M{"$in": keys}})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var tags []Tag
+	if err := cursor.All(context.TODO(), &tags); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return tags, nil
+}
+
+// CountTagsByUser counts how many of ids are tags of the user
+func (t *Tag) CountTagsByUser(userID primitive.ObjectID, ids []primitive.ObjectID) (int64, error) {
+	collection := returnTagsCollection("tags")
+	return collection.CountDocuments(context.TODO(), bson.M{"_user_id": userID, "_id": bson.M{"$in": ids}})
+}
+
+// InsertTag stores a new tag and returns it as stored
+func (t *Tag) InsertTag(entry Tag) (Tag, error) {
+	collection := returnTagsCollection("tags")
+	tag := Tag{
+		UserID:    entry.UserID,
+		Name:      strings.TrimSpace(entry.Name),
+		NameKey:   tagNameKey(entry.Name),
+		Color:     entry.Color,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	res, err := collection.InsertOne(context.TODO(), tag)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return Tag{}, ErrTagExists
+	}
+	if err != nil {
+		log.Println("Error: ", err)
+		return Tag{}, err
+	}
+	tag.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return tag, nil
+}
+
+// UpdateTag renames or recolors a tag
+func (t *Tag) UpdateTag(userID primitive.ObjectID, id string, entry Tag) error {
+	collection := returnTagsCollection("tags")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTagNotFound
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "_name", Value: strings.TrimSpace(entry.Name)},
+			{Key: "_name_key", Value: tagNameKey(entry.Name)},
+			{Key: "_color", Value: entry.Color},
+			{Key: "_updated_at", Value: time.Now()},
+		}},
+	}
+
+	res, err := collection.UpdateOne(context.TODO(), bson.M{"_id": mongoID, "_user_id": userID}, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrTagExists
+	}
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// MergeTags moves every todo tagged with source to target and deletes
+// source. Returns the number of todos that carried source.
+func (t *Tag) MergeTags(userID primitive.ObjectID, sourceID, targetID string) (int64, error) {
+	source, err := t.GetTagById(userID, sourceID)
+	if err != nil {
+		return 0, err
+	}
+	target, err := t.GetTagById(userID, targetID)
+	if err != nil {
+		return 0, err
+	}
+	sourceOID, _ := primitive.ObjectIDFromHex(source.ID)
+	targetOID, _ := primitive.ObjectIDFromHex(target.ID)
+	if sourceOID == targetOID {
+		return 0, ErrTagMergeSelf
+	}
+
+	todos := returnTodosCollection("todos")
+	filter := bson.M{"_user_id": userID, "_tag_ids": sourceOID}
+
+	// $addToSet and $pull on the same field cannot share one update.
+	res, err := todos.UpdateMany(context.TODO(), filter, bson.M{"$addToSet": bson.M{"_tag_ids": targetOID}})
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	_, err = todos.UpdateMany(context.TODO(), filter, bson.M{"$pull": bson.M{"_tag_ids": sourceOID}})
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	_, err = returnTagsCollection("tags").DeleteOne(context.TODO(), bson.M{"_id": sourceOID})
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return res.MatchedCount, nil
+}
+
+// DeleteTag removes the tag from every todo and deletes it. Returns the
+// number of todos it was removed from.
+func (t *Tag) DeleteTag(userID primitive.ObjectID, id string) (int64, error) {
+	tag, err := t.GetTagById(userID, id)
+	if err != nil {
+		return 0, err
+	}
+	mongoID, _ := primitive.ObjectIDFromHex(tag.ID)
+
+	res, err := returnTodosCollection("todos").UpdateMany(
+		context.TODO(),
+		bson.M{"_user_id": userID, "_tag_ids": mongoID},
+		bson.M{"$pull": bson.M{"_tag_ids": mongoID}},
+	)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	_, err = returnTagsCollection("tags").DeleteOne(context.TODO(), bson.M{"_id": mongoID})
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// GetTagSummary counts the user's todos per tag, tags without todos are
+// included with a zero count.
+func (t *Tag) GetTagSummary(userID primitive.ObjectID) (TagSummary, error) {
+	tags, err := t.GetTagsByUser(userID)
+	if err != nil {
+		return TagSummary{}, err
+	}
+
+	todos := returnTodosCollection("todos")
+	ctx := context.TODO()
+	summary := TagSummary{Items: []TagCount{}}
+
+	// Counted like the todo lists count, trashed and archived todos left out
+	listed := bson.M{"_user_id": userID, "_deleted_at": nil, "_archived_at": nil}
+	summary.Total, err = todos.CountDocuments(ctx, listed)
+	if err != nil {
+		log.Println(err)
+		return TagSummary{}, err
+	}
+	summary.Untagged, err = todos.CountDocuments(ctx, bson.M{
+		"_user_id":     userID,
+		"_deleted_at":  nil,
+		"_archived_at": nil,
+		"$or":          bson.A{bson.M{"_tag_ids": bson.M{"$exists": false}}, bson.M{"_tag_ids": bson.M{"$size": 0}}},
+	})
+	if err != nil {
+		log.Println(err)
+		return TagSummary{}, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: listed}},
+		{{Key: "$unwind", Value: "$_tag_ids"}},
+		{{Key: "$group", Value: bson.M{"_id": "$_tag_ids", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := todos.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return TagSummary{}, err
+	}
+	var groups []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Count int64              `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		log.Println(err)
+		return TagSummary{}, err
+	}
+
+	counts := map[string]int64{}
+	for _, group := range groups {
+		counts[group.ID.Hex()] = group.Count
+	}
+	for _, tag := range tags {
+		summary.Items = append(summary.Items, TagCount{Tag: tag, Count: counts[tag.ID]})
+	}
+	return summary, nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -11,16 +12,39 @@ import (
 )
 
 type TodoDetails struct {
-	ID              string    `json:"id,omitempty" bson:"_id,omitempty"`
-	TodoID          string    `json:"todo_id,omitempty" bson:"_todo_id,omitempty"`
-	TaskDetails     string    `json:"task_details" bson:"_task_details"`
-	NotesDetails    string    `json:"notes_details" bson:"_notes_details"`
-	StatusDetails   string    `json:"status_details" bson:"_status_details"`
-	PriorityDetails string    `json:"priority_details" bson:"_priority_details"`
-	CreatedAt       time.Time `json:"created_at,omitempty" bson:"_created_at,omitempty"`
-	UpdatedAt       time.Time `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+	ID              string             `json:"id,omitempty" bson:"_id,omitempty"`
+	TodoID          primitive.ObjectID `json:"todo_id,omitempty" bson:"_todo_id,omitempty"`
+	TaskDetails     string             `json:"task_details" bson:"_task_details"`
+	NotesDetails    string             `json:"notes_details" bson:"_notes_details"`
+	StatusDetails   string             `json:"status_details" bson:"_status_details"`
+	PriorityDetails string             `json:"priority_details" bson:"_priority_details"`
+	CreatedAt       time.Time          `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+	UpdatedAt       time.Time          `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+	// DeletedAt is set while the details are in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"_deleted_at,omitempty"`
+	// Version counts the writes to the details
+	Version int64 `json:"version" bson:"_version,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// UnmarshalBSON accepts both the current shape and documents written before
+// the v2 schema.
+func (t *TodoDetails) UnmarshalBSON(data []byte) error {
+	data, err := upgradeLegacyDocument(data)
+	if err != nil {
+		return err
+	}
+	type todoDetailsAlias TodoDetails
+	return bson.Unmarshal(data, (*todoDetailsAlias)(t))
 }
 
+var ErrTodoDetailsExists = errors.New("todo already has details")
+
+// ErrTodoDetailsTrashed is returned when details are created for a todo
+// whose details are in the trash, they are restored instead
+var ErrTodoDetailsTrashed = errors.New("todo details are in the trash")
+
 func NewTodoDetailsService(mongo *mongo.Client) TodoDetails {
 	client = mongo
 	return TodoDetails{}
@@ -30,11 +54,17 @@ func returnTodoDetailsCollection(collection string) *mongo.Collection {
 	return client.Database("todos_db").Collection(collection)
 }
 
-// GetAllTodosDetails - now exported
-func (t *TodoDetails) GetAllTodosDetails() ([]TodoDetails, error) {
+// GetAllTodosDetails returns the details of every todo of the user
+func (t *TodoDetails) GetAllTodosDetails(userID primitive.ObjectID) ([]TodoDetails, error) {
+	todoIDs, err := returnTodosCollection("todos").Distinct(context.TODO(), "_id", bson.M{"_user_id": userID, "_deleted_at": nil})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
 	collection := returnTodoDetailsCollection("todo_details")
-	var todoDetails []TodoDetails
-	cursor, err := collection.Find(context.TODO(), bson.D{})
+	todoDetails := []TodoDetails{}
+	cursor, err := collection.Find(context.TODO(), bson.M{"_todo_id": bson.M{"$in": todoIDs}, "_deleted_at": nil})
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -64,7 +94,7 @@ func (t *TodoDetails) GetTodoDetailsById(id string) (TodoDetails, error) {
 		return TodoDetails{}, err
 	}
 
-	err = collection.FindOne(context.TODO(), bson.M{"_id": mongoID}).Decode(&todoDetail)
+	err = collection.FindOne(context.TODO(), bson.M{"_id": mongoID, "_deleted_at": nil}).Decode(&todoDetail)
 	if err != nil {
 		log.Println(err)
 		return TodoDetails{}, err
@@ -73,10 +103,31 @@ func (t *TodoDetails) GetTodoDetailsById(id string) (TodoDetails, error) {
 	return todoDetail, nil
 }
 
-// InsertTodoDetails - create todo details
-func (t *TodoDetails) InsertTodoDetails(entry TodoDetails) error {
+// InsertTodoDetails - create todo details, the parent todo must exist. A
+// todo has one set of details, while its details are in the trash they
+// have to be restored or purged first. The details are returned as stored.
+func (t *TodoDetails) InsertTodoDetails(entry TodoDetails) (TodoDetails, error) {
 	collection := returnTodoDetailsCollection("todo_details")
-	_, err := collection.InsertOne(context.TODO(), TodoDetails{
+
+	count, err := returnTodosCollection("todos").CountDocuments(context.TODO(), bson.M{"_id": entry.TodoID, "_deleted_at": nil})
+	if err != nil {
+		log.Println(err)
+		return TodoDetails{}, err
+	}
+	if count == 0 {
+		return TodoDetails{}, ErrTodoNotFound
+	}
+
+	trashed, err := collection.CountDocuments(context.TODO(), bson.M{"_todo_id": entry.TodoID, "_deleted_at": bson.M{"$ne": nil}})
+	if err != nil {
+		log.Println(err)
+		return TodoDetails{}, err
+	}
+	if trashed > 0 {
+		return TodoDetails{}, ErrTodoDetailsTrashed
+	}
+
+	details := TodoDetails{
 		TodoID:          entry.TodoID,
 		TaskDetails:     entry.TaskDetails,
 		NotesDetails:    entry.NotesDetails,
@@ -84,22 +135,34 @@ func (t *TodoDetails) InsertTodoDetails(entry TodoDetails) error {
 		PriorityDetails: entry.PriorityDetails,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
-	})
+		Version:         1,
+
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	res, err := collection.InsertOne(context.TODO(), details)
 
+	if mongo.IsDuplicateKeyError(err) {
+		return TodoDetails{}, ErrTodoDetailsExists
+	}
 	if err != nil {
 		log.Println("Error: ", err)
-		return err
+		return TodoDetails{}, err
 	}
-	return nil
+	if err := touchTodo(context.TODO(), entry.TodoID); err != nil {
+		log.Println(err)
+	}
+	details.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return details, nil
 }
 
-// UpdateTodoDetails - update todo details
-func (t *TodoDetails) UpdateTodoDetails(id string, entry TodoDetails) (*mongo.UpdateResult, error) {
-	collection := returnTodoDetailsCollection("todo_details")
+// UpdateTodoDetails - update todo details. Missing details are
+// mongo.ErrNoDocuments, details no longer at version expected
+// ErrVersionMismatch.
+func (t *TodoDetails) UpdateTodoDetails(id string, entry TodoDetails, expected int64) error {
 	mongoID, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	update := bson.D{
@@ -110,45 +173,86 @@ func (t *TodoDetails) UpdateTodoDetails(id string, entry TodoDetails) (*mongo.Up
 			{Key: "_priority_details", Value: entry.PriorityDetails},
 			{Key: "_updated_at", Value: time.Now()},
 		}},
+		{Key: "$inc", Value: incVersion},
 	}
 
-	res, err := collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": mongoID},
-		update,
-	)
+	return t.updateVersioned(mongoID, expected, update)
+}
 
+// DeleteTodoDetails moves the details to the trash. Details no longer at
+// version expected are ErrVersionMismatch.
+func (t *TodoDetails) DeleteTodoDetails(id string, expected int64) error {
+	mongoID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return err
 	}
-	return res, nil
+	return t.updateVersioned(mongoID, expected, bson.M{
+		"$set": bson.M{"_deleted_at": time.Now()},
+		"$inc": incVersion,
+	})
 }
 
-// DeleteTodoDetails
-func (t *TodoDetails) DeleteTodoDetails(id string) error {
+// updateVersioned applies update to the details with id mongoID when they
+// are at version expected, and bumps the version of their todo
+func (t *TodoDetails) updateVersioned(mongoID primitive.ObjectID, expected int64, update interface{}) error {
 	collection := returnTodoDetailsCollection("todo_details")
-	mongoID, err := primitive.ObjectIDFromHex(id)
+	var before TodoDetails
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		withVersion(bson.M{"_id": mongoID, "_deleted_at": nil}, expected),
+		update,
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return versionConflict(collection, mongoID, expected, mongo.ErrNoDocuments)
+	}
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	_, err = collection.DeleteOne(
-		context.Background(),
-		bson.M{"_id": mongoID},
-	)
-	if err != nil {
+	if err := touchTodo(context.Background(), before.TodoID); err != nil {
 		log.Println(err)
-		return err
 	}
 	return nil
 }
 
+// GetTodoDetailsByTodoIds loads the details of several todos at once, keyed
+// by todo id. Todos without details are left out.
+func (t *TodoDetails) GetTodoDetailsByTodoIds(todoIDs []string) (map[string]TodoDetails, error) {
+	byTodo := map[string]TodoDetails{}
+	ids := objectIDs(todoIDs)
+	if len(ids) == 0 {
+		return byTodo, nil
+	}
+
+	cursor, err := returnTodoDetailsCollection("todo_details").Find(context.TODO(), bson.M{"_todo_id": bson.M{"$in": ids}, "_deleted_at": nil})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var details []TodoDetails
+	if err := cursor.All(context.TODO(), &details); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, detail := range details {
+		byTodo[detail.TodoID.Hex()] = detail
+	}
+	return byTodo, nil
+}
+
 func (t *TodoDetails) GetTodoDetailsByTodoId(todoId string) (TodoDetails, error) {
 	collection := returnTodoDetailsCollection("todo_details")
 	var todoDetail TodoDetails
 
-	err := collection.FindOne(context.TODO(), bson.M{"_todo_id": todoId}).Decode(&todoDetail)
+	mongoID, err := primitive.ObjectIDFromHex(todoId)
+	if err != nil {
+		return TodoDetails{}, err
+	}
+
+	// Documents not yet migrated to v3 still store the reference as a string.
+	filter := bson.M{"_todo_id": bson.M{"$in": bson.A{mongoID, todoId}}, "_deleted_at": nil}
+	err = collection.FindOne(context.TODO(), filter).Decode(&todoDetail)
 	if err != nil {
 		// If not found, return empty struct (not an error)
 		if err == mongo.ErrNoDocuments {
@@ -160,3 +264,64 @@ func (t *TodoDetails) GetTodoDetailsByTodoId(todoId string) (TodoDetails, error)
 
 	return todoDetail, nil
 }
+
+// FindOrphanedTodoDetails returns details whose todo reference is missing,
+// unreadable or points at a todo that no longer exists. Run the v3 migration
+// first, string references never match a todo _id.
+func (t *TodoDetails) FindOrphanedTodoDetails(ctx context.Context) ([]TodoDetails, error) {
+	collection := returnTodoDetailsCollection("todo_details")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "todos"},
+			{Key: "localField", Value: "_todo_id"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "_todo"},
+		}}},
+		{{Key: "$match", Value: bson.M{"_todo": bson.M{"$size": 0}}}},
+		{{Key: "$project", Value: bson.M{"_todo": 0}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var orphans []TodoDetails
+	if err := cursor.All(ctx, &orphans); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return orphans, nil
+}
+
+// DeleteOrphanedTodoDetails removes the given details, re-checking that
+// their todo is still missing so a concurrent insert is never lost.
+func (t *TodoDetails) DeleteOrphanedTodoDetails(ctx context.Context, orphans []TodoDetails) (int64, error) {
+	collection := returnTodoDetailsCollection("todo_details")
+
+	var deleted int64
+	for _, orphan := range orphans {
+		mongoID, err := primitive.ObjectIDFromHex(orphan.ID)
+		if err != nil {
+			return deleted, err
+		}
+		if !orphan.TodoID.IsZero() {
+			count, err := returnTodosCollection("todos").CountDocuments(ctx, bson.M{"_id": orphan.TodoID})
+			if err != nil {
+				return deleted, err
+			}
+			if count > 0 {
+				continue
+			}
+		}
+		res, err := collection.DeleteOne(ctx, bson.M{"_id": mongoID})
+		if err != nil {
+			log.Println(err)
+			return deleted, err
+		}
+		deleted += res.DeletedCount
+	}
+	return deleted, nil
+}
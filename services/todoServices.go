@@ -2,27 +2,106 @@ package services
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Todo struct {
-	ID        string     `json:"id,omitempty" bson:"_id,omitempty"`
-	UserID    string     `json:"user_id" bson:"_user_id"`
-	Task      string     `json:"task" bson:"_task"`
-	DateStart *time.Time `json:"date_start,omitempty" bson:"_date_start,omitempty"`
-	DateDue   *time.Time `json:"date_due,omitempty" bson:"_date_due,omitempty"`
-	Completed bool       `json:"completed" bson:"_completed"`
-	CreatedAt time.Time  `json:"created_at,omitempty" bson:"_created_at,omitempty"`
-	UpdatedAt time.Time  `json:"update_at,omitempty" bson:"_update_at,omitempty"`
+	ID        string             `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"_user_id,omitempty"`
+	Task      string             `json:"task" bson:"_task"`
+	DateStart *time.Time         `json:"date_start,omitempty" bson:"_date_start,omitempty"`
+	DateDue   *time.Time         `json:"date_due,omitempty" bson:"_date_due,omitempty"`
+	Completed bool               `json:"completed" bson:"_completed"`
+	// CompletedAt is when the todo was completed, cleared when it is reopened
+	CompletedAt *time.Time `json:"completed_at,omitempty" bson:"_completed_at,omitempty"`
+	// ArchivedAt is set while a completed todo is archived
+	ArchivedAt *time.Time `json:"archived_at,omitempty" bson:"_archived_at,omitempty"`
+	// AutoComplete completes the todo once every subtask is done
+	AutoComplete bool                 `json:"auto_complete" bson:"_auto_complete"`
+	TagIDs       []primitive.ObjectID `json:"tag_ids" bson:"_tag_ids,omitempty"`
+	ProjectID    *primitive.ObjectID  `json:"project_id" bson:"_project_id,omitempty"`
+	Recurrence   *Recurrence          `json:"recurrence,omitempty" bson:"_recurrence,omitempty"`
+	// BlockedBy are the todos that have to be done before this one
+	BlockedBy []primitive.ObjectID `json:"blocked_by" bson:"_blocked_by,omitempty"`
+	// Rank orders the todo within its project or the inbox
+	Rank      string    `json:"rank" bson:"_rank,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+	// DeletedAt is set while the todo is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"_deleted_at,omitempty"`
+	// Version counts the writes to the todo and its details
+	Version int64 `json:"version" bson:"_version,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// UnmarshalBSON accepts both the current shape and documents written before
+// the v2 schema.
+func (t *Todo) UnmarshalBSON(data []byte) error {
+	data, err := upgradeLegacyDocument(data)
+	if err != nil {
+		return err
+	}
+	type todoAlias Todo
+	return bson.Unmarshal(data, (*todoAlias)(t))
 }
 
 var client *mongo.Client
 
+var ErrTodoNotFound = errors.New("todo not found")
+
+// TodoFilter narrows GetAllTodos, zero fields match everything
+type TodoFilter struct {
+	UserID primitive.ObjectID
+	// ProjectID limits the result to one project, Inbox to todos without
+	// a project
+	ProjectID *primitive.ObjectID
+	Inbox     bool
+	TagIDs    []primitive.ObjectID
+	// MatchAnyTag returns todos carrying at least one of TagIDs instead of
+	// all of them
+	MatchAnyTag bool
+	// Archived lists archived todos instead of the ones not archived
+	Archived bool
+	// CompletedFrom and CompletedTo limit the result to todos completed in
+	// [CompletedFrom, CompletedTo)
+	CompletedFrom *time.Time
+	CompletedTo   *time.Time
+}
+
+func (f TodoFilter) query() bson.M {
+	query := bson.M{"_deleted_at": nil, "_archived_at": nil}
+	if f.Archived {
+		query["_archived_at"] = bson.M{"$ne": nil}
+	}
+	if f.CompletedFrom != nil && f.CompletedTo != nil {
+		query["_completed_at"] = bson.M{"$gte": *f.CompletedFrom, "$lt": *f.CompletedTo}
+	}
+	if !f.UserID.IsZero() {
+		query["_user_id"] = f.UserID
+	}
+	if f.ProjectID != nil {
+		query["_project_id"] = *f.ProjectID
+	} else if f.Inbox {
+		query["_project_id"] = bson.M{"$exists": false}
+	}
+	if len(f.TagIDs) > 0 {
+		if f.MatchAnyTag {
+			query["_tag_ids"] = bson.M{"$in": f.TagIDs}
+		} else {
+			query["_tag_ids"] = bson.M{"$all": f.TagIDs}
+		}
+	}
+	return query
+}
+
 func New(mongo *mongo.Client) Todo {
 	client = mongo
 	return Todo{}
@@ -33,10 +112,10 @@ func returnTodosCollection(collection string) *mongo.Collection {
 }
 
 // GetAllTodos
-func (t *Todo) GetAllTodos() ([]Todo, error) {
+func (t *Todo) GetAllTodos(filter TodoFilter) ([]Todo, error) {
 	collection := returnTodosCollection("todos")
 	var todos []Todo
-	cursor, err := collection.Find(context.TODO(), bson.D{})
+	cursor, err := collection.Find(context.TODO(), filter.query(), options.Find().SetSort(rankSort))
 	if err != nil {
 		log.Fatal(err)
 		return nil, err
@@ -63,7 +142,8 @@ func (t *Todo) GetTodoById(id string) (Todo, error) {
 		return Todo{}, err
 	}
 
-	err = collection.FindOne(context.TODO(), bson.M{"_id": mongoID}).Decode(&todo)
+	// Trashed todos are only reachable through the trash
+	err = collection.FindOne(context.TODO(), bson.M{"_id": mongoID, "_deleted_at": nil}).Decode(&todo)
 	if err != nil {
 		log.Println(err)
 		return Todo{}, err
@@ -73,27 +153,39 @@ func (t *Todo) GetTodoById(id string) (Todo, error) {
 }
 
 // InsertTodo
-func (t *Todo) InsertTodo(entry Todo) error {
+func (t *Todo) InsertTodo(entry Todo) (Todo, error) {
 	collection := returnTodosCollection("todos")
-	_, err := collection.InsertOne(context.TODO(), Todo{
-		Task:      entry.Task,
-		DateStart: entry.DateStart,
-		DateDue:   entry.DateDue,
-		Completed: entry.Completed,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
+	todo := Todo{
+		UserID:       entry.UserID,
+		Task:         entry.Task,
+		TagIDs:       entry.TagIDs,
+		ProjectID:    entry.ProjectID,
+		DateStart:    entry.DateStart,
+		DateDue:      entry.DateDue,
+		Completed:    entry.Completed,
+		AutoComplete: entry.AutoComplete,
+		Recurrence:   entry.Recurrence,
+		Rank:         lastRank(collection, todoListFilter(entry.UserID, entry.ProjectID)),
+		CompletedAt:  completedAt(entry.Completed),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		Version:      1,
+
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	res, err := collection.InsertOne(context.TODO(), todo)
 
 	if err != nil {
 		log.Println("Error: ", err)
-		return err
+		return Todo{}, err
 	}
-	return nil
+	todo.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return todo, nil
 }
 
-// UpdatedTodo
-
-func (t *Todo) UpdatedTodo(id string, entry Todo) (*mongo.UpdateResult, error) {
+// UpdatedTodo replaces the editable fields of the todo. It fails with
+// ErrVersionMismatch when the todo is no longer at version expected.
+func (t *Todo) UpdatedTodo(id string, entry Todo, expected int64) (*mongo.UpdateResult, error) {
 	collection := returnTodosCollection("todos")
 	mongoID, err := primitive.ObjectIDFromHex(id)
 
@@ -107,13 +199,18 @@ func (t *Todo) UpdatedTodo(id string, entry Todo) (*mongo.UpdateResult, error) {
 			{Key: "_date_start", Value: entry.DateStart},
 			{Key: "_date_due", Value: entry.DateDue},
 			{Key: "_completed", Value: entry.Completed},
-			{Key: "_update_at", Value: time.Now()},
+			{Key: "_auto_complete", Value: entry.AutoComplete},
+			{Key: "_updated_at", Value: time.Now()},
 		}},
+		{Key: "$inc", Value: incVersion},
+	}
+	if !entry.Completed {
+		update = append(update, bson.E{Key: "$unset", Value: bson.M{"_completed_at": "", "_archived_at": ""}})
 	}
 
 	res, err := collection.UpdateOne(
 		context.Background(),
-		bson.M{"_id": mongoID},
+		withVersion(bson.M{"_id": mongoID}, expected),
 		update,
 	)
 
@@ -121,25 +218,440 @@ func (t *Todo) UpdatedTodo(id string, entry Todo) (*mongo.UpdateResult, error) {
 		log.Println(err)
 		return nil, err
 	}
+	if res.MatchedCount == 0 && expected != AnyVersion {
+		return nil, versionConflict(collection, mongoID, expected, ErrTodoNotFound)
+	}
+	if entry.Completed {
+		stampCompletedAt(mongoID)
+	}
 	return res, nil
 }
 
-// DeleteTodo
+// TodoPatch holds the fields a partial update sets, nil fields keep their
+// value. ClearDateStart and ClearDateDue remove a date.
+type TodoPatch struct {
+	Task           *string
+	DateStart      *time.Time
+	ClearDateStart bool
+	DateDue        *time.Time
+	ClearDateDue   bool
+	Completed      *bool
+	AutoComplete   *bool
+}
+
+// Apply returns todo with the patch applied, the way PatchTodo will store it
+func (p TodoPatch) Apply(todo Todo) Todo {
+	if p.Task != nil {
+		todo.Task = *p.Task
+	}
+	if p.DateStart != nil || p.ClearDateStart {
+		todo.DateStart = p.DateStart
+	}
+	if p.DateDue != nil || p.ClearDateDue {
+		todo.DateDue = p.DateDue
+	}
+	if p.Completed != nil {
+		todo.Completed = *p.Completed
+	}
+	if p.AutoComplete != nil {
+		todo.AutoComplete = *p.AutoComplete
+	}
+	return todo
+}
+
+// PatchTodo updates only the fields set in patch and returns the todo as
+// stored afterwards. It fails with ErrVersionMismatch when the todo is no
+// longer at version expected.
+func (t *Todo) PatchTodo(id string, patch TodoPatch, expected int64) (Todo, error) {
+	collection := returnTodosCollection("todos")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Todo{}, ErrTodoNotFound
+	}
+
+	set := bson.M{"_updated_at": time.Now()}
+	unset := bson.M{}
+	if patch.Task != nil {
+		set["_task"] = *patch.Task
+	}
+	if patch.DateStart != nil {
+		set["_date_start"] = *patch.DateStart
+	} else if patch.ClearDateStart {
+		unset["_date_start"] = ""
+	}
+	if patch.DateDue != nil {
+		set["_date_due"] = *patch.DateDue
+	} else if patch.ClearDateDue {
+		unset["_date_due"] = ""
+	}
+	if patch.Completed != nil {
+		set["_completed"] = *patch.Completed
+		if !*patch.Completed {
+			unset["_completed_at"] = ""
+			unset["_archived_at"] = ""
+		}
+	}
+	if patch.AutoComplete != nil {
+		set["_auto_complete"] = *patch.AutoComplete
+	}
+
+	update := bson.M{"$set": set, "$inc": incVersion}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	filter := withVersion(bson.M{"_id": mongoID, "_deleted_at": nil}, expected)
+	res, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.Println(err)
+		return Todo{}, err
+	}
+	if res.MatchedCount == 0 {
+		return Todo{}, versionConflict(collection, mongoID, expected, ErrTodoNotFound)
+	}
+	if patch.Completed != nil && *patch.Completed {
+		stampCompletedAt(mongoID)
+	}
+	return t.GetTodoById(id)
+}
+
+func completedAt(completed bool) *time.Time {
+	if !completed {
+		return nil
+	}
+	now := time.Now()
+	return &now
+}
 
-func (t *Todo) DeleteTodo(id string) error {
+// stampCompletedAt records when a todo got completed, keeping the time of
+// the first completion when it is saved again as completed
+func stampCompletedAt(mongoID primitive.ObjectID) {
+	_, err := returnTodosCollection("todos").UpdateOne(
+		context.Background(),
+		bson.M{"_id": mongoID, "_completed": true, "_completed_at": nil},
+		bson.M{"$set": bson.M{"_completed_at": time.Now()}},
+	)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// SetTodoCompleted only changes the completion state of the todo
+func (t *Todo) SetTodoCompleted(id string, completed bool) error {
 	collection := returnTodosCollection("todos")
 	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{
+		"_completed":  completed,
+		"_updated_at": time.Now(),
+	}, "$inc": incVersion}
+	if !completed {
+		update["$unset"] = bson.M{"_completed_at": "", "_archived_at": ""}
+	}
+
+	_, err = collection.UpdateOne(context.Background(), bson.M{"_id": mongoID}, update)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	_, err = collection.DeleteOne(
+	if completed {
+		stampCompletedAt(mongoID)
+	}
+	return nil
+}
+
+// ErrTodoBlocked is returned when completing a todo that open todos
+// still block
+var ErrTodoBlocked = errors.New("todo is blocked by open todos")
+
+// errCompletionUnchanged tells that the todo was left as it was
+var errCompletionUnchanged = errors.New("completion unchanged")
+
+// ToggleTodoCompleted flips the completion state of the todo in a single
+// update, so two quick toggles never read the same state. A blocked todo
+// can only be reopened, completing it fails with ErrTodoBlocked. It
+// returns the todo as it was before the toggle.
+func (t *Todo) ToggleTodoCompleted(id string, blocked bool, expected int64) (Todo, error) {
+	now := time.Now()
+	// Every expression of the stage reads the todo as it was
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"_completed":    bson.M{"$not": bson.A{"$_completed"}},
+		"_completed_at": bson.M{"$cond": bson.A{"$_completed", "$$REMOVE", now}},
+		"_archived_at":  bson.M{"$cond": bson.A{"$_completed", "$$REMOVE", "$_archived_at"}},
+		"_updated_at":   now,
+		"_version":      bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$_version", 0}}, 1}},
+	}}}}
+
+	filter := bson.M{}
+	if blocked {
+		filter["_completed"] = true
+	}
+	before, err := updateCompletion(id, filter, update, expected)
+	if errors.Is(err, errCompletionUnchanged) {
+		return Todo{}, ErrTodoBlocked
+	}
+	return before, err
+}
+
+// CompleteTodo completes or reopens the todo. Asking for the state the
+// todo already has changes nothing and returns false, so the call can be
+// repeated safely. It returns the todo as it was before.
+func (t *Todo) CompleteTodo(id string, completed bool, expected int64) (Todo, bool, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{"_completed": true, "_completed_at": now, "_updated_at": now},
+		"$inc": incVersion,
+	}
+	if !completed {
+		update = bson.M{
+			"$set":   bson.M{"_completed": false, "_updated_at": now},
+			"$unset": bson.M{"_completed_at": "", "_archived_at": ""},
+			"$inc":   incVersion,
+		}
+	}
+
+	before, err := updateCompletion(id, bson.M{"_completed": bson.M{"$ne": completed}}, update, expected)
+	if errors.Is(err, errCompletionUnchanged) {
+		return before, false, nil
+	}
+	return before, err == nil, err
+}
+
+// updateCompletion applies update to the todo when it matches filter and
+// returns the todo as it was before. When the todo does not match, it is
+// returned as it is with errCompletionUnchanged.
+func updateCompletion(id string, filter bson.M, update interface{}, expected int64) (Todo, error) {
+	collection := returnTodosCollection("todos")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Todo{}, ErrTodoNotFound
+	}
+	filter["_id"] = mongoID
+	filter["_deleted_at"] = nil
+
+	var before Todo
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = collection.FindOneAndUpdate(context.Background(), withVersion(filter, expected), update, opts).Decode(&before)
+	if err != mongo.ErrNoDocuments {
+		if err != nil {
+			log.Println(err)
+		}
+		return before, err
+	}
+
+	var current Todo
+	err = collection.FindOne(context.Background(), bson.M{"_id": mongoID, "_deleted_at": nil}).Decode(&current)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return Todo{}, ErrTodoNotFound
+	case err != nil:
+		log.Println(err)
+		return Todo{}, err
+	case expected != AnyVersion && current.Version != expected:
+		return Todo{}, ErrVersionMismatch
+	}
+	return current, errCompletionUnchanged
+}
+
+// SetTodoTags replaces the tags of one of the user's todos
+func (t *Todo) SetTodoTags(userID primitive.ObjectID, id string, tagIDs []primitive.ObjectID) error {
+	collection := returnTodosCollection("todos")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+
+	res, err := collection.UpdateOne(
 		context.Background(),
-		bson.M{"_id": mongoID},
+		bson.M{"_id": mongoID, "_user_id": userID},
+		bson.M{"$set": bson.M{
+			"_tag_ids":    tagIDs,
+			"_updated_at": time.Now(),
+		}, "$inc": incVersion},
 	)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return ErrTodoNotFound
+	}
 	return nil
 }
+
+// SetTodoProject moves one of the user's todos to a project, nil moves it
+// back to the inbox
+func (t *Todo) SetTodoProject(userID primitive.ObjectID, id string, projectID *primitive.ObjectID) error {
+	collection := returnTodosCollection("todos")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTodoNotFound
+	}
+
+	// The todo goes to the end of its new list
+	rank := lastRank(collection, todoListFilter(userID, projectID))
+	update := bson.M{"$set": bson.M{"_project_id": projectID, "_rank": rank, "_updated_at": time.Now()}, "$inc": incVersion}
+	if projectID == nil {
+		update = bson.M{"$unset": bson.M{"_project_id": ""}, "$set": bson.M{"_rank": rank, "_updated_at": time.Now()}, "$inc": incVersion}
+	}
+
+	res, err := collection.UpdateOne(context.Background(), bson.M{"_id": mongoID, "_user_id": userID}, update)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTodoNotFound
+	}
+	return nil
+}
+
+// ownerlessTodos matches todos created before todos had owners, the v3
+// migration removes their empty _user_id
+var ownerlessTodos = bson.M{"$or": bson.A{
+	bson.M{"_user_id": bson.M{"$exists": false}},
+	bson.M{"_user_id": ""},
+}}
+
+// CountOwnerlessTodos counts the todos AssignOwner would hand out
+func (t *Todo) CountOwnerlessTodos(ctx context.Context) (int64, error) {
+	return returnTodosCollection("todos").CountDocuments(ctx, ownerlessTodos)
+}
+
+// AssignOwner gives every todo without an owner to userID. Todos created
+// before todos had owners are otherwise invisible in GetAllTodos.
+func (t *Todo) AssignOwner(userID primitive.ObjectID) (int64, error) {
+	collection := returnTodosCollection("todos")
+	res, err := collection.UpdateMany(
+		context.Background(),
+		ownerlessTodos,
+		bson.M{"$set": bson.M{"_user_id": userID}},
+	)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// todoChildCollections hold documents that reference a todo via _todo_id
+// and are removed together with it. Activities are kept, the log is only
+// ever appended to.
+var todoChildCollections = []string{"todo_details", "subtasks", "reminders", "comments", "attachments"}
+
+type DeleteTodoResult struct {
+	TodoID        string           `json:"todo_id"`
+	Transactional bool             `json:"transactional"`
+	Removed       map[string]int64 `json:"removed"`
+}
+
+// PurgeTodo permanently removes the todo and everything that belongs to it. On a
+// replica set this happens in one transaction; on a standalone server the
+// children are removed first and the todo last, so a purge that fails
+// halfway leaves the todo in the trash and purging it again finishes the job.
+func (t *Todo) PurgeTodo(id string) (DeleteTodoResult, error) {
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Println(err)
+		return DeleteTodoResult{}, ErrTodoNotFound
+	}
+
+	ctx := context.Background()
+	result := DeleteTodoResult{TodoID: id, Removed: map[string]int64{}}
+	// Attachment contents go once their metadata is gone for good
+	keys := attachmentKeys(ctx, mongoID)
+
+	if supportsTransactions(ctx) {
+		result.Transactional = true
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error {
+			removed, err := deleteTodoAndChildren(sc, mongoID)
+			result.Removed = removed
+			return err
+		})
+		if err != nil {
+			if !errors.Is(err, ErrTodoNotFound) {
+				log.Println(err)
+			}
+			return DeleteTodoResult{}, err
+		}
+		deleteBlobs(keys)
+		return result, nil
+	}
+
+	result.Removed, err = deleteTodoAndChildren(ctx, mongoID)
+	if _, ok := result.Removed["attachments"]; ok {
+		deleteBlobs(keys)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrTodoNotFound) {
+			log.Println(err)
+		}
+		return DeleteTodoResult{}, err
+	}
+	return result, nil
+}
+
+// deleteTodoAndChildren removes what belongs to the todo, then the todo
+// itself. It stops at the first failure, the todo is only gone once
+// nothing refers to it anymore.
+func deleteTodoAndChildren(ctx context.Context, mongoID primitive.ObjectID) (map[string]int64, error) {
+	removed := map[string]int64{}
+
+	count, err := returnTodosCollection("todos").CountDocuments(ctx, bson.M{"_id": mongoID})
+	if err != nil {
+		return removed, err
+	}
+	if count == 0 {
+		return removed, ErrTodoNotFound
+	}
+
+	// Documents not yet migrated to v3 still store the reference as a string.
+	children := bson.M{"_todo_id": bson.M{"$in": bson.A{mongoID, mongoID.Hex()}}}
+	for _, name := range todoChildCollections {
+		res, err := returnTodosCollection(name).DeleteMany(ctx, children)
+		if err != nil {
+			return removed, err
+		}
+		removed[name] = res.DeletedCount
+	}
+
+	// Todos it blocked are no longer blocked by it
+	_, err = returnTodosCollection("todos").UpdateMany(ctx,
+		bson.M{"_blocked_by": mongoID},
+		bson.M{"$pull": bson.M{"_blocked_by": mongoID}},
+	)
+	if err != nil {
+		return removed, err
+	}
+
+	_, err = returnActivitiesCollection("activities").UpdateMany(ctx,
+		bson.M{"_todo_id": mongoID, "_todo_purged_at": nil},
+		bson.M{"$set": bson.M{"_todo_purged_at": time.Now()}},
+	)
+	if err != nil {
+		return removed, err
+	}
+
+	res, err := returnTodosCollection("todos").DeleteOne(ctx, bson.M{"_id": mongoID})
+	if err != nil {
+		return removed, err
+	}
+	if res.DeletedCount == 0 {
+		return removed, ErrTodoNotFound
+	}
+	removed["todos"] = res.DeletedCount
+	return removed, nil
+}
+
+// objectIDs parses ids, leaving out those that are not valid
+func objectIDs(ids []string) []primitive.ObjectID {
+	var parsed []primitive.ObjectID
+	for _, id := range ids {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			parsed = append(parsed, oid)
+		}
+	}
+	return parsed
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	transactionsOnce      sync.Once
+	transactionsSupported bool
+)
+
+// supportsTransactions reports whether the server accepts multi-document
+// transactions, i.e. it is a replica set member or a mongos. A standalone
+// server like the one in docker-compose.yml does not.
+func supportsTransactions(ctx context.Context) bool {
+	transactionsOnce.Do(func() {
+		var hello bson.M
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err != nil {
+			log.Println("Could not detect server topology: ", err)
+			return
+		}
+		_, replicaSet := hello["setName"]
+		transactionsSupported = replicaSet || hello["msg"] == "isdbgrid"
+		log.Printf("MongoDB transactions supported: %v\n", transactionsSupported)
+	})
+	return transactionsSupported
+}
+
+// withTransaction runs fn inside a transaction. Callers check
+// supportsTransactions first and provide their own fallback otherwise.
+func withTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// What a trash item is
+const (
+	TrashKindTodo        = "todo"
+	TrashKindTodoDetails = "todo_details"
+)
+
+// TrashItem is a todo, or the details of a todo, waiting in the trash
+type TrashItem struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	TodoID    string    `json:"todo_id"`
+	Title     string    `json:"title"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type PurgeResult struct {
+	Todos       int64 `json:"todos"`
+	TodoDetails int64 `json:"todo_details"`
+}
+
+// TrashTodoResult tells what went to the trash with a todo: its details,
+// and the subtasks, reminders, comments and attachments only reachable
+// through it until it is restored
+type TrashTodoResult struct {
+	TodoID        string           `json:"todo_id"`
+	Transactional bool             `json:"transactional"`
+	Trashed       map[string]int64 `json:"trashed"`
+}
+
+// trashedWithTodo are the collections whose documents go to the trash
+// with their todo without being marked themselves
+var trashedWithTodo = []string{"subtasks", "reminders", "comments", "attachments"}
+
+var (
+	ErrTrashItemNotFound = errors.New("trash item not found")
+	// ErrParentTrashed is returned when restoring details of a todo that
+	// is still in the trash itself
+	ErrParentTrashed = errors.New("todo of these details is in the trash")
+)
+
+// TrashTodo moves one of the user's todos to the trash together with its
+// details. Both get the same timestamp, so restoring the todo brings back
+// exactly what was trashed with it.
+//
+// The todo has to be at version expected, or the trashing fails with
+// ErrVersionMismatch. Without transactions a todo whose details could not
+// be trashed is taken out of the trash again.
+func (t *Todo) TrashTodo(userID primitive.ObjectID, id string, expected int64) (TrashTodoResult, error) {
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return TrashTodoResult{}, ErrTodoNotFound
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	result := TrashTodoResult{TodoID: id, Trashed: map[string]int64{}}
+	trash := func(ctx context.Context) error {
+		collection := returnTodosCollection("todos")
+		res, err := collection.UpdateOne(ctx,
+			withVersion(bson.M{"_id": mongoID, "_user_id": userID, "_deleted_at": nil}, expected),
+			bson.M{"$set": bson.M{"_deleted_at": now}, "$inc": incVersion},
+		)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return versionConflict(collection, mongoID, expected, ErrTodoNotFound)
+		}
+
+		result.Trashed["todos"] = 1
+
+		details, err := returnTodoDetailsCollection("todo_details").UpdateMany(ctx,
+			bson.M{"_todo_id": mongoID, "_deleted_at": nil},
+			bson.M{"$set": bson.M{"_deleted_at": now}},
+		)
+		if err != nil {
+			return err
+		}
+		result.Trashed["todo_details"] = details.ModifiedCount
+
+		children := bson.M{"_todo_id": bson.M{"$in": bson.A{mongoID, mongoID.Hex()}}}
+		for _, name := range trashedWithTodo {
+			count, err := returnTodosCollection(name).CountDocuments(ctx, children)
+			if err != nil {
+				return err
+			}
+			result.Trashed[name] = count
+		}
+		return nil
+	}
+
+	if supportsTransactions(ctx) {
+		result.Transactional = true
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error { return trash(sc) })
+	} else {
+		err = trash(ctx)
+		if err != nil && result.Trashed["todos"] > 0 {
+			_, restoreErr := returnTodosCollection("todos").UpdateOne(ctx,
+				bson.M{"_id": mongoID, "_deleted_at": now},
+				bson.M{"$unset": bson.M{"_deleted_at": ""}},
+			)
+			if restoreErr != nil {
+				log.Println("Could not take todo ", id, " out of the trash: ", restoreErr)
+			}
+			_, restoreErr = returnTodoDetailsCollection("todo_details").UpdateMany(ctx,
+				bson.M{"_todo_id": mongoID, "_deleted_at": now},
+				bson.M{"$unset": bson.M{"_deleted_at": ""}},
+			)
+			if restoreErr != nil {
+				log.Println("Could not take details of todo ", id, " out of the trash: ", restoreErr)
+			}
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, ErrTodoNotFound) && !errors.Is(err, ErrVersionMismatch) {
+			log.Println(err)
+		}
+		return TrashTodoResult{}, err
+	}
+	return result, nil
+}
+
+// TodoInTrash tells whether the todo id is in the trash, as opposed to
+// purged or never existing
+func (t *Todo) TodoInTrash(id string) (bool, error) {
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+	count, err := returnTodosCollection("todos").CountDocuments(context.TODO(), bson.M{"_id": mongoID, "_deleted_at": bson.M{"$ne": nil}})
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetTrash lists what the user has in the trash, latest first
+func (t *Todo) GetTrash(userID primitive.ObjectID) ([]TrashItem, error) {
+	ctx := context.TODO()
+	items := []TrashItem{}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_deleted_at", Value: -1}})
+	cursor, err := returnTodosCollection("todos").Find(ctx, bson.M{"_user_id": userID, "_deleted_at": bson.M{"$ne": nil}}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var todos []Todo
+	if err := cursor.All(ctx, &todos); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, todo := range todos {
+		items = append(items, TrashItem{
+			ID:        todo.ID,
+			Kind:      TrashKindTodo,
+			TodoID:    todo.ID,
+			Title:     todo.Task,
+			DeletedAt: *todo.DeletedAt,
+		})
+	}
+
+	// Details trashed on their own, the todo they belong to tells the owner
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_deleted_at": bson.M{"$ne": nil}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "todos"},
+			{Key: "localField", Value: "_todo_id"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "_todo"},
+		}}},
+		{{Key: "$match", Value: bson.M{"_todo._user_id": userID, "_todo._deleted_at": nil}}},
+		{{Key: "$project", Value: bson.M{"_todo": 0}}},
+		{{Key: "$sort", Value: bson.M{"_deleted_at": -1}}},
+	}
+	cursor, err = returnTodoDetailsCollection("todo_details").Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var details []TodoDetails
+	if err := cursor.All(ctx, &details); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, detail := range details {
+		items = append(items, TrashItem{
+			ID:        detail.ID,
+			Kind:      TrashKindTodoDetails,
+			TodoID:    detail.TodoID.Hex(),
+			Title:     detail.TaskDetails,
+			DeletedAt: *detail.DeletedAt,
+		})
+	}
+	return items, nil
+}
+
+// RestoreFromTrash brings back a trashed todo, with the details trashed
+// together with it, or trashed details. A restored todo whose project is
+// gone lands in the inbox.
+func (t *Todo) RestoreFromTrash(userID primitive.ObjectID, id string) (TrashItem, error) {
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return TrashItem{}, ErrTrashItemNotFound
+	}
+	ctx := context.TODO()
+	todos := returnTodosCollection("todos")
+	details := returnTodoDetailsCollection("todo_details")
+
+	var todo Todo
+	err = todos.FindOne(ctx, bson.M{"_id": mongoID, "_user_id": userID, "_deleted_at": bson.M{"$ne": nil}}).Decode(&todo)
+	if err == nil {
+		update := bson.M{"$unset": bson.M{"_deleted_at": ""}, "$set": bson.M{"_updated_at": time.Now()}, "$inc": incVersion}
+		if todo.ProjectID != nil {
+			count, err := returnProjectsCollection("projects").CountDocuments(ctx, bson.M{"_id": *todo.ProjectID})
+			if err != nil {
+				log.Println(err)
+				return TrashItem{}, err
+			}
+			if count == 0 {
+				update["$unset"] = bson.M{"_deleted_at": "", "_project_id": ""}
+			}
+		}
+		if _, err := todos.UpdateOne(ctx, bson.M{"_id": mongoID}, update); err != nil {
+			log.Println(err)
+			return TrashItem{}, err
+		}
+		_, err = details.UpdateMany(ctx,
+			bson.M{"_todo_id": mongoID, "_deleted_at": *todo.DeletedAt},
+			bson.M{"$unset": bson.M{"_deleted_at": ""}},
+		)
+		if err != nil {
+			log.Println(err)
+			return TrashItem{}, err
+		}
+		return TrashItem{ID: todo.ID, Kind: TrashKindTodo, TodoID: todo.ID, Title: todo.Task, DeletedAt: *todo.DeletedAt}, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return TrashItem{}, err
+	}
+
+	var detail TodoDetails
+	err = details.FindOne(ctx, bson.M{"_id": mongoID, "_deleted_at": bson.M{"$ne": nil}}).Decode(&detail)
+	if err == mongo.ErrNoDocuments {
+		return TrashItem{}, ErrTrashItemNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return TrashItem{}, err
+	}
+	if err := todos.FindOne(ctx, bson.M{"_id": detail.TodoID, "_user_id": userID}).Decode(&todo); err != nil {
+		return TrashItem{}, ErrTrashItemNotFound
+	}
+	if todo.DeletedAt != nil {
+		return TrashItem{}, ErrParentTrashed
+	}
+
+	// Details created after these were trashed replaced them
+	count, err := details.CountDocuments(ctx, bson.M{"_todo_id": detail.TodoID, "_deleted_at": nil})
+	if err != nil {
+		log.Println(err)
+		return TrashItem{}, err
+	}
+	if count > 0 {
+		return TrashItem{}, ErrTodoDetailsExists
+	}
+
+	_, err = details.UpdateOne(ctx, bson.M{"_id": mongoID}, bson.M{"$unset": bson.M{"_deleted_at": ""}, "$inc": incVersion})
+	if err != nil {
+		log.Println(err)
+		return TrashItem{}, err
+	}
+	if err := touchTodo(ctx, detail.TodoID); err != nil {
+		log.Println(err)
+	}
+	return TrashItem{ID: detail.ID, Kind: TrashKindTodoDetails, TodoID: detail.TodoID.Hex(), Title: detail.TaskDetails, DeletedAt: *detail.DeletedAt}, nil
+}
+
+// PurgeTrashItem permanently removes one of the user's trashed todos or
+// details
+func (t *Todo) PurgeTrashItem(userID primitive.ObjectID, id string) error {
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTrashItemNotFound
+	}
+	ctx := context.TODO()
+
+	count, err := returnTodosCollection("todos").CountDocuments(ctx, bson.M{"_id": mongoID, "_user_id": userID, "_deleted_at": bson.M{"$ne": nil}})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if count > 0 {
+		_, err := t.PurgeTodo(id)
+		return err
+	}
+
+	var detail TodoDetails
+	err = returnTodoDetailsCollection("todo_details").FindOne(ctx, bson.M{"_id": mongoID, "_deleted_at": bson.M{"$ne": nil}}).Decode(&detail)
+	if err == mongo.ErrNoDocuments {
+		return ErrTrashItemNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	count, err = returnTodosCollection("todos").CountDocuments(ctx, bson.M{"_id": detail.TodoID, "_user_id": userID})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if count == 0 {
+		return ErrTrashItemNotFound
+	}
+
+	_, err = returnTodoDetailsCollection("todo_details").DeleteOne(ctx, bson.M{"_id": mongoID})
+	return err
+}
+
+// PurgeTrash permanently removes everything trashed before "before"
+func (t *Todo) PurgeTrash(ctx context.Context, before time.Time) (PurgeResult, error) {
+	var result PurgeResult
+	filter := bson.M{"_deleted_at": bson.M{"$lt": before}}
+
+	cursor, err := returnTodosCollection("todos").Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return result, err
+	}
+	var ids []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &ids); err != nil {
+		return result, err
+	}
+	for _, doc := range ids {
+		_, err := t.PurgeTodo(doc.ID.Hex())
+		if errors.Is(err, ErrTodoNotFound) {
+			// Purged or restored meanwhile
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Todos++
+	}
+
+	// Details trashed on their own, details trashed with their todo went
+	// with it
+	res, err := returnTodoDetailsCollection("todo_details").DeleteMany(ctx, filter)
+	if err != nil {
+		return result, err
+	}
+	result.TodoDetails = res.DeletedCount
+	return result, nil
+}
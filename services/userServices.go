@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -11,18 +12,78 @@ import (
 )
 
 type User struct {
-	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
-	FirstName string    `json:"first_name,omitempty" bson:"_first_name,omitempty"`
-	LastName  string    `json:"last_name,omitempty" bson:"_last_name,omitempty"`
-	Email     string    `json:"email,omitempty" bson:"_email,omitempty"`
-	Password  string    `json:"password,omitempty" bson:"_password,omitempty"`
-	CreatedAt time.Time `json:"created_at,omitempty" bson:"_created_at,omitempty"`
-	UpdatedAt time.Time `json:"update_at,omitempty" bson:"_update_at,omitempty"`
+	ID        string `json:"id,omitempty" bson:"_id,omitempty"`
+	FirstName string `json:"first_name,omitempty" bson:"_first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty" bson:"_last_name,omitempty"`
+	Email     string `json:"email,omitempty" bson:"_email,omitempty"`
+	Password  string `json:"password,omitempty" bson:"_password,omitempty"`
+	// Timezone is an IANA name such as Asia/Jakarta, digests follow it
+	Timezone        string    `json:"timezone,omitempty" bson:"_timezone,omitempty"`
+	DigestFrequency string    `json:"digest_frequency,omitempty" bson:"_digest_frequency,omitempty"`
+	CreatedAt       time.Time `json:"created_at,omitempty" bson:"_created_at,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at,omitempty" bson:"_updated_at,omitempty"`
+
+	SchemaVersion int `json:"-" bson:"_schema_version,omitempty"`
+}
+
+// UnmarshalBSON accepts both the current shape and documents written before
+// the v2 schema.
+func (u *User) UnmarshalBSON(data []byte) error {
+	data, err := upgradeLegacyDocument(data)
+	if err != nil {
+		return err
+	}
+	type userAlias User
+	return bson.Unmarshal(data, (*userAlias)(u))
+}
+
+// How often a user gets a digest, no frequency means daily
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+	DigestOff    = "off"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// Location returns the user's timezone, UTC when unset or unknown
+func (u User) Location() *time.Location {
+	if u.Timezone != "" {
+		if loc, err := time.LoadLocation(u.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// ValidateUserPreferences checks a timezone and digest frequency before
+// they are stored
+func ValidateUserPreferences(timezone string, frequency string) error {
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return &PreferenceError{Field: "timezone", Message: "unknown timezone " + timezone}
+		}
+	}
+	switch frequency {
+	case "", DigestDaily, DigestWeekly, DigestOff:
+		return nil
+	}
+	return &PreferenceError{Field: "digest_frequency", Message: "digest_frequency must be daily, weekly or off"}
+}
+
+// PreferenceError tells which preference did not validate
+type PreferenceError struct {
+	Field   string
+	Message string
+}
+
+func (e *PreferenceError) Error() string {
+	return e.Message
 }
 
 type UserService interface {
 	GetAllUsers() ([]User, error)
-	InsertUser(entry User) error
+	InsertUser(entry User) (User, error)
 }
 
 func retunrUserCollection(collection string) *mongo.Collection {
@@ -49,23 +110,30 @@ func (u *User) GetAllUsers() ([]User, error) {
 	return users, nil
 }
 
-func (u *User) InsertUser(entry User) error {
+// InsertUser stores a new user and returns it as stored
+func (u *User) InsertUser(entry User) (User, error) {
 	collection := retunrUserCollection("users")
-	_, err := collection.InsertOne(context.TODO(), User{
+	user := User{
 		FirstName: entry.FirstName,
 		LastName:  entry.LastName,
 		Email:     entry.Email,
 		Password:  entry.Password,
+		Timezone:  entry.Timezone,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
-	})
+
+		DigestFrequency: entry.DigestFrequency,
+		SchemaVersion:   CurrentSchemaVersion,
+	}
+	res, err := collection.InsertOne(context.TODO(), user)
 
 	if err != nil {
 		log.Println("Error: ", err)
-		return err
+		return User{}, err
 	}
 
-	return nil
+	user.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return user, nil
 }
 
 func (u *User) GetUserByID(id string) (User, error) {
@@ -87,3 +155,49 @@ func (u *User) GetUserByID(id string) (User, error) {
 	}
 	return user, nil
 }
+
+// UpdateUserPreferences stores the user's timezone and digest frequency
+func (u *User) UpdateUserPreferences(id string, timezone string, frequency string) error {
+	collection := retunrUserCollection("users")
+	mongoID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	res, err := collection.UpdateOne(context.TODO(), bson.M{"_id": mongoID}, bson.M{"$set": bson.M{
+		"_timezone":         timezone,
+		"_digest_frequency": frequency,
+		"_updated_at":       time.Now(),
+	}})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// GetDigestRecipients returns the users with an email address who have not
+// turned digests off
+func (u *User) GetDigestRecipients(ctx context.Context) ([]User, error) {
+	collection := retunrUserCollection("users")
+	filter := bson.M{
+		"_email":            bson.M{"$exists": true, "$ne": ""},
+		"_digest_frequency": bson.M{"$ne": DigestOff},
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Every write to a todo or its details increments _version. Writes given
+// an expected version only apply when the document is still at it, which
+// is how two clients editing the same todo find out about each other.
+
+// AnyVersion skips the version check of a write
+const AnyVersion int64 = -1
+
+// ErrVersionMismatch is returned when the document changed since the
+// version the write expected
+var ErrVersionMismatch = errors.New("version does not match")
+
+// incVersion is the part of an update that bumps the version
+var incVersion = bson.M{"_version": 1}
+
+// withVersion narrows filter to documents at the expected version.
+// Documents written before versions existed have none and are at 0.
+func withVersion(filter bson.M, expected int64) bson.M {
+	switch {
+	case expected == AnyVersion:
+	case expected == 0:
+		filter["_version"] = bson.M{"$in": bson.A{0, nil}}
+	default:
+		filter["_version"] = expected
+	}
+	return filter
+}
+
+// versionConflict tells why a versioned write to the document with id
+// mongoID matched nothing: it is gone, or it is at another version
+func versionConflict(collection *mongo.Collection, mongoID primitive.ObjectID, expected int64, notFound error) error {
+	if expected == AnyVersion {
+		return notFound
+	}
+	count, err := collection.CountDocuments(context.TODO(), bson.M{"_id": mongoID, "_deleted_at": nil})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return notFound
+	}
+	return ErrVersionMismatch
+}
+
+// touchTodo bumps the version of the todo some details belong to, the
+// details are part of what a todo reads as
+func touchTodo(ctx context.Context, todoID primitive.ObjectID) error {
+	_, err := returnTodosCollection("todos").UpdateOne(ctx, bson.M{"_id": todoID}, bson.M{"$inc": incVersion})
+	return err
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Priority levels for TodoDetails.PriorityDetails
+const (
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+// Workflow states for TodoDetails.StatusDetails
+const (
+	StatusBacklog    = "backlog"
+	StatusTodo       = "todo"
+	StatusInProgress = "in_progress"
+	StatusBlocked    = "blocked"
+	StatusDone       = "done"
+)
+
+var Priorities = []string{PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent}
+
+var Statuses = []string{StatusBacklog, StatusTodo, StatusInProgress, StatusBlocked, StatusDone}
+
+// statusTransitions lists where each status may move to. Staying in the
+// same status is always allowed.
+var statusTransitions = map[string][]string{
+	StatusBacklog:    {StatusTodo, StatusInProgress},
+	StatusTodo:       {StatusBacklog, StatusInProgress, StatusDone},
+	StatusInProgress: {StatusTodo, StatusBlocked, StatusDone},
+	StatusBlocked:    {StatusInProgress, StatusTodo},
+	StatusDone:       {StatusTodo, StatusInProgress},
+}
+
+// Spellings found in existing data, keyed by their normalized form.
+var priorityAliases = map[string]string{
+	"low": PriorityLow, "minor": PriorityLow,
+	"medium": PriorityMedium, "med": PriorityMedium, "normal": PriorityMedium,
+	"high": PriorityHigh, "important": PriorityHigh,
+	"urgent": PriorityUrgent, "critical": PriorityUrgent, "asap": PriorityUrgent,
+}
+
+var statusAliases = map[string]string{
+	"backlog": StatusBacklog, "later": StatusBacklog,
+	"todo": StatusTodo, "open": StatusTodo, "new": StatusTodo, "pending": StatusTodo,
+	"inprogress": StatusInProgress, "doing": StatusInProgress, "wip": StatusInProgress, "started": StatusInProgress,
+	"blocked": StatusBlocked, "onhold": StatusBlocked, "waiting": StatusBlocked,
+	"done": StatusDone, "complete": StatusDone, "completed": StatusDone, "finished": StatusDone, "closed": StatusDone,
+}
+
+// normalizeEnum lowercases value and drops everything that is not a letter,
+// so "High", " HIGH " and "urgent!!" all compare equal to their alias key.
+func normalizeEnum(value string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if r >= 'a' && r <= 'z' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// ParsePriority returns the canonical priority for value.
+func ParsePriority(value string) (string, error) {
+	if priority, ok := priorityAliases[normalizeEnum(value)]; ok {
+		return priority, nil
+	}
+	return "", fmt.Errorf("invalid priority %q, use one of %s", value, strings.Join(Priorities, ", "))
+}
+
+// ParseStatus returns the canonical status for value.
+func ParseStatus(value string) (string, error) {
+	if status, ok := statusAliases[normalizeEnum(value)]; ok {
+		return status, nil
+	}
+	return "", fmt.Errorf("invalid status %q, use one of %s", value, strings.Join(Statuses, ", "))
+}
+
+// CanTransition reports whether a todo may move from one status to another.
+func CanTransition(from, to string) bool {
+	if from == to || from == "" {
+		return true
+	}
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedTransitions returns a copy of the status workflow.
+func AllowedTransitions() map[string][]string {
+	transitions := make(map[string][]string, len(statusTransitions))
+	for from, to := range statusTransitions {
+		transitions[from] = append([]string(nil), to...)
+	}
+	return transitions
+}
+
+// SyncStatusWithCompletion keeps the details status in line with
+// Todo.Completed after the todo was completed or reopened.
+func (t *TodoDetails) SyncStatusWithCompletion(todoID string, completed bool) error {
+	collection := returnTodoDetailsCollection("todo_details")
+	mongoID, err := primitive.ObjectIDFromHex(todoID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_todo_id":        bson.M{"$in": bson.A{mongoID, todoID}},
+		"_deleted_at":     nil,
+		"_status_details": StatusDone,
+	}
+	status := StatusTodo
+	if completed {
+		filter["_status_details"] = bson.M{"$ne": StatusDone}
+		status = StatusDone
+	}
+
+	_, err = collection.UpdateOne(context.TODO(), filter, bson.M{"$set": bson.M{
+		"_status_details": status,
+		"_updated_at":     time.Now(),
+	}, "$inc": incVersion})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func normalizeDetailsEnums(doc bson.Raw) (bson.D, error) {
+	set := bson.D{}
+	fields := []struct {
+		name     string
+		parse    func(string) (string, error)
+		fallback string
+	}{
+		{"_status_details", ParseStatus, StatusTodo},
+		{"_priority_details", ParsePriority, PriorityMedium},
+	}
+
+	for _, field := range fields {
+		value, ok := doc.Lookup(field.name).StringValueOK()
+		if !ok {
+			continue
+		}
+		canonical, err := field.parse(value)
+		if value == "" {
+			canonical = field.fallback
+		} else if err != nil {
+			// Keep what the user typed next to the document.
+			canonical = field.fallback
+			set = append(set, bson.E{Key: "_legacy" + field.name, Value: value})
+		}
+		if canonical != value {
+			set = append(set, bson.E{Key: field.name, Value: canonical})
+		}
+	}
+
+	if len(set) == 0 {
+		return bson.D{}, nil
+	}
+	return bson.D{{Key: "$set", Value: set}}, nil
+}